@@ -0,0 +1,122 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/netip"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// allowlist is the list of clients approved to be connected to the cluster.
+// It is read from a YAML file of the following form, entries can be IP
+// addresses, networks in CIDR notation or fully qualified domain names:
+//
+//	clients:
+//	  - 10.7.3.67
+//	  - 10.7.4.0/24
+//	  - webserver.fqdn.tld
+type allowlist struct {
+	Clients []string `yaml:"clients"`
+
+	ips   map[netip.Addr]bool
+	nets  []netip.Prefix
+	names map[string]bool
+}
+
+func readAllowlist(name string) (*allowlist, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &allowlist{
+		ips:   make(map[netip.Addr]bool),
+		names: make(map[string]bool),
+	}
+	if err := yaml.Unmarshal(b, a); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	for _, e := range a.Clients {
+		if n, err := netip.ParsePrefix(e); err == nil {
+			a.nets = append(a.nets, n.Masked())
+			continue
+		}
+		if ip, err := netip.ParseAddr(e); err == nil {
+			a.ips[ip] = true
+			continue
+		}
+		a.names[normalizeName(e)] = true
+	}
+
+	return a, nil
+}
+
+// allowed reports whether the client is approved.
+func (a *allowlist) allowed(c *Client) bool {
+	if matchAddrs(c, a.ips, a.nets) {
+		return true
+	}
+	for _, name := range strings.Fields(c.FQDN) {
+		if a.names[normalizeName(name)] {
+			return true
+		}
+	}
+	return false
+}
+
+// missing returns the approved addresses and names for which no client is
+// connected. Networks are never reported as missing.
+func (a *allowlist) missing(clients []*Client) []string {
+	seen := make(map[string]bool)
+	for _, c := range clients {
+		for _, ip := range c.addrs() {
+			seen[ip.String()] = true
+		}
+		for _, name := range strings.Fields(c.FQDN) {
+			seen[normalizeName(name)] = true
+		}
+	}
+
+	var m []string
+	for _, e := range a.Clients {
+		key := normalizeName(e)
+		if ip, err := netip.ParseAddr(e); err == nil {
+			key = ip.String()
+		} else if _, err := netip.ParsePrefix(e); err == nil {
+			continue
+		}
+		if !seen[key] {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+// matchAddrs reports whether one of the addresses of the client is one of ips
+// or part of one of nets.
+func matchAddrs(c *Client, ips map[netip.Addr]bool, nets []netip.Prefix) bool {
+	for _, ip := range c.addrs() {
+		if ips[ip] {
+			return true
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeName returns the lower case name without the trailing dot of fully
+// qualified domain names.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/netip"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// annotations are notes of operators about specific clients, kept in a YAML
+// file next to the reports so they show up in every run. Clients are
+// identified by IP or fqdn:
+//
+//	notes:
+//	  10.7.3.64: legacy backup box, upgrade scheduled KW34
+//	  hyper01.fqdn.tld: rebooted into the new kernel on the next window
+type annotations struct {
+	ips   map[netip.Addr]string
+	names map[string]string
+}
+
+func readAnnotations(name string) (*annotations, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Notes map[string]string `yaml:"notes"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	a := &annotations{
+		ips:   make(map[netip.Addr]string),
+		names: make(map[string]string),
+	}
+	for k, note := range f.Notes {
+		if ip, err := netip.ParseAddr(k); err == nil {
+			a.ips[ip.Unmap()] = note
+			continue
+		}
+		a.names[normalizeName(k)] = note
+	}
+	return a, nil
+}
+
+// note returns the notes of the client, matching any of its addresses or
+// names, separated by "; ".
+func (a *annotations) note(c *Client) string {
+	var notes []string
+	for _, ip := range c.addrs() {
+		if n, ok := a.ips[ip]; ok {
+			notes = append(notes, n)
+		}
+	}
+	for _, name := range strings.Fields(c.FQDN) {
+		if n, ok := a.names[normalizeName(name)]; ok {
+			notes = append(notes, n)
+		}
+	}
+	return strings.Join(notes, "; ")
+}
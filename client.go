@@ -0,0 +1,493 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unknown is used for fields which could not be determined.
+const unknown = "unknown"
+
+// Client represents a connected client.
+type Client struct {
+	Addr netip.Addr `json:"addr"`
+	// MergedAddrs are further addresses of the client merged into it by
+	// -merge-dual-stack.
+	MergedAddrs []netip.Addr `json:"merged_addrs,omitempty"`
+	Family      string       `json:"family"`
+	Entity      Entity       `json:"entity"`
+	// GlobalID is zero if the monitor did not report it.
+	GlobalID uint64 `json:"global_id,omitempty"`
+	// GlobalIDStatus is the global_id reclaim status (e.g. reclaim_insecure),
+	// only reported by monitors with the fix for CVE-2021-20288.
+	GlobalIDStatus string       `json:"global_id_status,omitempty"`
+	State          SessionState `json:"state"`
+	Features       FeatureMask  `json:"features"`
+	Release        Release      `json:"release"`
+	FQDN           string       `json:"fqdn,omitempty"`
+	// MixedRelease is set if other clients resolving to the same FQDN
+	// report a different release.
+	MixedRelease bool `json:"mixed_release"`
+	// Msgr is the messenger protocol of the session (v1 or v2). Monitors
+	// listen on port 6789 for v1 and 3300 for v2 by default.
+	Msgr string `json:"msgr"`
+	// Watches are the RBD images the client holds a watch on, only
+	// collected with -watchers or -all-watchers.
+	Watches []string `json:"watches,omitempty"`
+	// SeenAt is the time the session was collected.
+	SeenAt time.Time `json:"seen_at"`
+	// Resolved is the output of the -resolve-cmd for the client.
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// IP returns the address of the client as string, followed by the merged
+// addresses separated by spaces.
+func (c *Client) IP() string {
+	ip := c.Addr.String()
+	for _, a := range c.MergedAddrs {
+		ip += " " + a.String()
+	}
+	return ip
+}
+
+// addrs returns all addresses of the client.
+func (c *Client) addrs() []netip.Addr {
+	return append([]netip.Addr{c.Addr}, c.MergedAddrs...)
+}
+
+// Keys sessions can be deduplicated by.
+const (
+	dedupIP       = "ip"
+	dedupEntity   = "entity"
+	dedupIPEntity = "ip+entity"
+)
+
+// dedupKey returns the key identifying duplicate sessions of the client.
+func (c *Client) dedupKey(by string) string {
+	switch by {
+	case dedupEntity:
+		return string(c.Entity)
+	case dedupIPEntity:
+		return c.IP() + " " + string(c.Entity)
+	}
+	return c.IP()
+}
+
+func (c *Client) String() string {
+	return c.IP() + c.Features.String() + string(c.Release)
+}
+
+// FeatureMask is the feature bit mask announced by a client. Zero means the
+// features are unknown.
+type FeatureMask uint64
+
+// parseFeatureMask parses a hexadecimal feature mask with or without 0x
+// prefix.
+func parseFeatureMask(s string) (FeatureMask, error) {
+	f, err := strconv.ParseUint(trimHexPrefix(s), 16, 64)
+	return FeatureMask(f), err
+}
+
+// Known reports whether the features are known.
+func (f FeatureMask) Known() bool {
+	return f != 0
+}
+
+// Has reports whether all bits of mask are set.
+func (f FeatureMask) Has(mask uint64) bool {
+	return f.Known() && uint64(f)&mask == mask
+}
+
+// String returns the mask in hexadecimal notation as reported by Ceph, e.g.
+// 0x3ffddff8eea4fffb, or unknown.
+func (f FeatureMask) String() string {
+	if !f.Known() {
+		return unknown
+	}
+	return "0x" + strconv.FormatUint(uint64(f), 16)
+}
+
+func (f FeatureMask) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *FeatureMask) UnmarshalText(b []byte) error {
+	if string(b) == unknown {
+		*f = 0
+		return nil
+	}
+	m, err := parseFeatureMask(string(b))
+	if err != nil {
+		return err
+	}
+	*f = m
+	return nil
+}
+
+// Entity is the name of a Ceph entity, e.g. client.admin or osd.1.
+type Entity string
+
+// Type returns the type of the entity (e.g. client, osd, mgr) or an empty
+// string if unknown.
+func (e Entity) Type() string {
+	if i := strings.Index(string(e), "."); i > 0 {
+		return string(e[:i])
+	}
+	return ""
+}
+
+// SessionState is the state of a monitor session.
+type SessionState string
+
+// session is a single session as reported by newer monitors, which no longer
+// format sessions as strings.
+type session struct {
+	Name       string `json:"name"`
+	EntityName string `json:"entity_name"`
+	SocketAddr struct {
+		Type string `json:"type"`
+		Addr string `json:"addr"`
+	} `json:"socket_addr"`
+	FeaturesHex     string `json:"con_features_hex"`
+	FeaturesRelease string `json:"con_features_release"`
+	Open            *bool  `json:"open"`
+	GlobalID        uint64 `json:"global_id"`
+	GlobalIDStatus  string `json:"global_id_status"`
+}
+
+// parseSessions parses the output of 'ceph daemon mon.<id> sessions', a list
+// of session strings or, on newer monitors, session objects. Output which is
+// not JSON, as printed by pre-luminous monitors, is parsed as plain text.
+func parseSessions(b []byte) ([]*Client, error) {
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] != '[' {
+		// Skip text printed before the JSON sessions, e.g. by shell
+		// startup files or a forced command on the host.
+		i := bytes.Index(t, []byte("\n["))
+		if i < 0 {
+			return parsePlainSessions(t)
+		}
+		b = t[i+1:]
+	}
+
+	// Decode one session at a time instead of the whole list at once, so
+	// that only one raw session is held in addition to the output.
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if d, ok := t.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("expected a list of sessions, got %v", t)
+	}
+
+	clients := []*Client{}
+	var lastErr error
+	for dec.More() {
+		var r json.RawMessage
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		// A single session which can not be parsed should not fail the
+		// whole monitor.
+		c := &Client{}
+		if err := c.parseSession(r); err != nil {
+			log.Printf("skipping session %s: %v\n", head(r, 120), err)
+			lastErr = err
+			continue
+		}
+		clients = append(clients, c)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return clients, nil
+}
+
+func (c *Client) parseSession(b []byte) error {
+	if len(b) > 0 && b[0] == '{' {
+		return c.parseSessionObject(b)
+	}
+
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	return c.parseSessionString(str)
+}
+
+// parsePlainSessions parses the plain text output of old monitors, which
+// print one session string per line.
+func parsePlainSessions(b []byte) ([]*Client, error) {
+	var clients []*Client
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		i := strings.Index(line, "MonSession(")
+		if i < 0 {
+			continue
+		}
+		c := &Client{}
+		if err := c.parseSessionString(strings.TrimRight(line[i:], " \t\",")); err != nil {
+			log.Printf("skipping session %q: %v\n", line[i:], err)
+			continue
+		}
+		clients = append(clients, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return nil, errors.New("no sessions found in plain text output")
+	}
+	return clients, nil
+}
+
+func (c *Client) parseSessionString(str string) error {
+	// A session string has the following format:
+	// "MonSession(mon.0 10.7.3.65:6789/0 is open allow *, features 0x3ffddff8eea4fffb (luminous))"
+	fields := strings.Split(str, " ")
+	if len(fields) < 9 {
+		return c.parsePermissive(fields)
+	}
+
+	msgr, addr := splitMsgr(fields[1])
+	ip, err := parseAddr(addr)
+	if err != nil {
+		return c.parsePermissive(fields)
+	}
+
+	c.Addr = ip
+	c.Msgr = msgr
+	c.Family = addrFamily(ip)
+	c.Entity = Entity(strings.TrimPrefix(fields[0], "MonSession("))
+	c.GlobalID = entityGlobalID(c.Entity)
+	c.State = parseState(fields)
+	c.Features, _ = parseFeatureMask(fields[len(fields)-2])
+	c.Release = parseRelease(strings.TrimSuffix(strings.TrimPrefix(fields[len(fields)-1], "("), "))"))
+
+	return nil
+}
+
+func (c *Client) parseSessionObject(b []byte) error {
+	var s session
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	ip, err := parseAddr(s.SocketAddr.Addr)
+	if err != nil {
+		return err
+	}
+
+	c.Addr = ip
+	c.Msgr = unknown
+	if s.SocketAddr.Type == "v1" || s.SocketAddr.Type == "v2" {
+		c.Msgr = s.SocketAddr.Type
+	}
+	c.Family = addrFamily(ip)
+	c.Entity = Entity(s.EntityName)
+	if c.Entity == "" {
+		c.Entity = Entity(s.Name)
+	}
+	c.GlobalID = entityGlobalID(Entity(s.Name))
+	if s.GlobalID != 0 {
+		c.GlobalID = s.GlobalID
+	}
+	c.GlobalIDStatus = s.GlobalIDStatus
+	c.State = unknown
+	if s.Open != nil {
+		c.State = stateClosed
+		if *s.Open {
+			c.State = stateOpen
+		}
+	}
+	if s.FeaturesHex != "" {
+		c.Features, _ = parseFeatureMask(s.FeaturesHex)
+	}
+	c.Release = unknown
+	if s.FeaturesRelease != "" {
+		c.Release = parseRelease(s.FeaturesRelease)
+	}
+
+	return nil
+}
+
+// parsePermissive parses session strings of very old monitors, which differ in
+// the number of fields and may lack the feature annotation. Only the address is
+// required, missing fields are set to unknown.
+func (c *Client) parsePermissive(fields []string) error {
+	c.Addr = netip.Addr{}
+	c.Msgr = "v1"
+	c.Features = 0
+	c.Release = unknown
+
+	if len(fields) > 0 {
+		c.Entity = Entity(strings.TrimPrefix(fields[0], "MonSession("))
+		c.GlobalID = entityGlobalID(c.Entity)
+	}
+	c.State = parseState(fields)
+
+	for _, f := range fields {
+		f = strings.TrimRight(f, "),")
+		if !c.Addr.IsValid() {
+			msgr, addr := splitMsgr(f)
+			if ip, err := parseAddr(addr); err == nil {
+				c.Addr = ip
+				c.Msgr = msgr
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(f, "0x"):
+			c.Features, _ = parseFeatureMask(f)
+		case strings.HasPrefix(f, "(") && len(f) > 1:
+			c.Release = parseRelease(strings.TrimPrefix(f, "("))
+		}
+	}
+
+	if !c.Addr.IsValid() {
+		return errors.New("unable to parse session string. no address found")
+	}
+	c.Family = addrFamily(c.Addr)
+
+	return nil
+}
+
+// parseAddr returns the IP of a session address of the form ip:port/nonce.
+func parseAddr(addr string) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return netip.ParseAddr(host)
+}
+
+// splitMsgr splits the messenger type prefix (v1: or v2:) from a session
+// address. Addresses without prefix are reported by monitors which only speak
+// the v1 protocol.
+func splitMsgr(addr string) (string, string) {
+	// Daemons with multiple addresses print an addrvec instead, e.g.
+	// [v2:10.7.3.10:6800/2,v1:10.7.3.10:6801/2], use its first address.
+	if strings.HasPrefix(addr, "[v1:") || strings.HasPrefix(addr, "[v2:") {
+		addr = strings.SplitN(strings.TrimSuffix(addr, "]"), ",", 2)[0][1:]
+	}
+	for _, t := range []string{"v1", "v2"} {
+		if strings.HasPrefix(addr, t+":") {
+			return t, strings.TrimPrefix(addr, t+":")
+		}
+	}
+	return "v1", addr
+}
+
+// globalIDReclaimInsecure is the global_id status of clients reclaiming their
+// global_id in an insecure way, which will be refused once
+// auth_allow_insecure_global_id_reclaim is set to false.
+const globalIDReclaimInsecure = "reclaim_insecure"
+
+// entityGlobalID returns the global id of a client entity name of the form
+// "client.<global id>", which older monitors report instead of an explicit
+// global_id, or zero.
+func entityGlobalID(entity Entity) uint64 {
+	id := strings.TrimPrefix(string(entity), "client.")
+	if id == string(entity) {
+		return 0
+	}
+	gid, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return gid
+}
+
+// Session states as stored in Client.State.
+const (
+	stateOpen   SessionState = "open"
+	stateClosed SessionState = "closed"
+)
+
+// parseState returns the state following the "is" token of a session string
+// (e.g. "... 10.7.3.65:6789/0 is open allow *, ...").
+func parseState(fields []string) SessionState {
+	for i, f := range fields {
+		if f == "is" && i+1 < len(fields) {
+			return SessionState(strings.TrimRight(fields[i+1], ",)"))
+		}
+	}
+	return unknown
+}
+
+// Client kinds as returned by Client.Kind.
+const (
+	kindKernel   = "kernel"
+	kindLibrados = "librados"
+	kindRGW      = "rgw"
+	kindMgr      = "mgr"
+	kindDaemon   = "daemon"
+)
+
+// Kind classifies the client based on its entity name and features.
+func (c *Client) Kind() string {
+	switch c.Entity.Type() {
+	case "mon", "osd", "mds":
+		return kindDaemon
+	case "mgr":
+		return kindMgr
+	case "client":
+		if strings.HasPrefix(string(c.Entity), "client.rgw") {
+			return kindRGW
+		}
+		if isKernelClient(c) {
+			return kindKernel
+		}
+		return kindLibrados
+	}
+	return unknown
+}
+
+// Implementations of clients, see Implementation.
+const (
+	implKernel    = "kernel"
+	implUserspace = "userspace"
+)
+
+// Implementation guesses whether the client is the kernel client (krbd,
+// kernel CephFS), which is upgraded by updating the kernel and rebooting, or a
+// userspace library or daemon, which is upgraded by updating the packages and
+// restarting the process. Clients with unknown features are unknown.
+func (c *Client) Implementation() string {
+	if !c.Features.Known() || c.Entity.Type() == "" {
+		return unknown
+	}
+	if isKernelClient(c) {
+		return implKernel
+	}
+	return implUserspace
+}
+
+// isKernelClient guesses whether the client is a kernel client. Kernel clients
+// never announce feature bit 0 (CEPH_FEATURE_UID), while all userspace
+// clients do.
+func isKernelClient(c *Client) bool {
+	return c.Features.Known() && !c.Features.Has(1)
+}
+
+// isLocal reports whether the client connected from a loopback or link-local
+// address, e.g. daemons colocated with the monitor.
+func (c *Client) isLocal() bool {
+	return c.Addr.IsLoopback() || c.Addr.IsLinkLocalUnicast()
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func FuzzParseSessions(f *testing.F) {
+	b, err := ioutil.ReadFile("internal/fakemon/testdata/sessions.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(b)
+	f.Add(fakeSessions(3))
+	f.Add([]byte("MonSession(client.84123 10.7.3.67:0/1234 is open allow *, features 0x3ffddff8eea4fffb (luminous))\n"))
+	f.Add([]byte("Last login: Mon Oct 12\n[\"MonSession(osd.1 [fd00::5]:6800/2 is open allow profile osd, features 0x3ffddff8eea4fffb (luminous))\"]"))
+	f.Add([]byte(`[{"entity_name":"client.admin","addrs":{"addrvec":[]},"con_features_hex":"zz"}]`))
+	f.Add([]byte("MonSession("))
+	f.Add([]byte("[]"))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		clients, err := parseSessions(b)
+		if err != nil {
+			return
+		}
+		for _, c := range clients {
+			if c == nil {
+				t.Fatal("nil client without error")
+			}
+			// The values written to the output must not panic either.
+			for _, col := range defaultColumns() {
+				col.value(c)
+			}
+		}
+	})
+}
+
+func TestParseSessions(t *testing.T) {
+	b, err := ioutil.ReadFile("internal/fakemon/testdata/sessions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := parseSessions(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.7.3.67", "10.7.3.64", "10.7.3.10", "10.7.3.80", "127.0.0.1"}
+	if len(clients) < len(want) {
+		t.Fatalf("%d clients, want at least %d", len(clients), len(want))
+	}
+	for i, ip := range want {
+		if got := clients[i].IP(); got != ip {
+			t.Errorf("client %d: IP %s, want %s", i, got, ip)
+		}
+	}
+}
+
+func TestParseSessionsErrors(t *testing.T) {
+	const s = `"MonSession(client.84123 10.7.3.67:0/1234 is open allow *, features 0x3ffddff8eea4fffb (luminous))"`
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "[]", want: 0},
+		{in: "[" + s + "]", want: 1},
+		{in: "[" + s + "," + s + "]\n", want: 2},
+		{in: "Welcome\n[" + s + "]", want: 1},
+		{in: "[" + s, wantErr: true},
+		{in: "[" + s + ",]", wantErr: true},
+		{in: "[" + s + " " + s + "]", wantErr: true},
+		{in: "[1]", wantErr: true},
+		{in: "{}", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		clients, err := parseSessions([]byte(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSessions(%q) error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if len(clients) != tt.want {
+			t.Errorf("parseSessions(%q) = %d clients, want %d", tt.in, len(clients), tt.want)
+		}
+	}
+}
+
+func TestParseSessionString(t *testing.T) {
+	tests := []struct {
+		in      string
+		ip      string
+		msgr    string
+		entity  Entity
+		release Release
+	}{
+		{"MonSession(client.84123 10.7.3.67:0/1234 is open allow *, features 0x3ffddff8eea4fffb (luminous))", "10.7.3.67", "v1", "client.84123", "luminous"},
+		{"MonSession(client.84123 v2:10.7.3.67:0/1234 is open allow *, features 0x3ffddff8eea4fffb (luminous))", "10.7.3.67", "v2", "client.84123", "luminous"},
+		{"MonSession(osd.1 [v2:10.7.3.10:6800/2,v1:10.7.3.10:6801/2] is open allow profile osd, features 0x3f01cfb8ffedffff (luminous))", "10.7.3.10", "v2", "osd.1", "luminous"},
+		{"MonSession(osd.2 [v1:10.7.3.11:6801/2] is open allow profile osd, features 0x3f01cfb8ffedffff (luminous))", "10.7.3.11", "v1", "osd.2", "luminous"},
+		{"MonSession(osd.3 [v2:[fd00::5]:6800/2,v1:[fd00::5]:6801/2] is open allow profile osd, features 0x3f01cfb8ffedffff (luminous))", "fd00::5", "v2", "osd.3", "luminous"},
+		{"MonSession(osd.4 [fd00::6]:6800/2 is open allow profile osd, features 0x3ffddff8eea4fffb (luminous))", "fd00::6", "v1", "osd.4", "luminous"},
+		{"MonSession(mgr.x [v2:10.7.3.12:6800/3,v1:10.7.3.12:6801/3] is open)", "10.7.3.12", "v2", "mgr.x", unknown},
+	}
+	for _, tt := range tests {
+		c := &Client{}
+		if err := c.parseSessionString(tt.in); err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if c.IP() != tt.ip || c.Msgr != tt.msgr || c.Entity != tt.entity || c.Release != tt.release {
+			t.Errorf("%s: got %s %s %s %s, want %s %s %s %s", tt.in, c.IP(), c.Msgr, c.Entity, c.Release, tt.ip, tt.msgr, tt.entity, tt.release)
+		}
+	}
+}
+
+func TestParseSessionsSkipsUnparsable(t *testing.T) {
+	in := `["MonSession(client.1 10.7.3.67:0/1 is open allow *, features 0x3ffddff8eea4fffb (luminous))",
+		"MonSession(client.2 garbage is open)",
+		"MonSession(osd.1 [v2:10.7.3.10:6800/2,v1:10.7.3.10:6801/2] is open allow profile osd, features 0x3f01cfb8ffedffff (luminous))"]`
+	clients, err := parseSessions([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients[0].IP() != "10.7.3.67" || clients[1].IP() != "10.7.3.10" {
+		t.Errorf("got %v, want the first and last session", clients)
+	}
+
+	plain := "MonSession(client.2 garbage is open)\nMonSession(client.1 10.7.3.67:0/1 is open allow *, features 0x3ffddff8eea4fffb (luminous))\n"
+	if clients, err := parseSessions([]byte(plain)); err != nil || len(clients) != 1 {
+		t.Errorf("plain: got %v, %v, want one client", clients, err)
+	}
+}
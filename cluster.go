@@ -0,0 +1,216 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cluster is a named Ceph cluster and its monitors. The clusters file passed
+// using -clusters has the following form:
+//
+//	clusters:
+//	  - name: prod
+//	    monitors: [mon1, mon2, mon3]
+//	  - name: lab
+//	    monitors: [labmon1]
+type cluster struct {
+	Name     string   `yaml:"name"`
+	Monitors []string `yaml:"monitors"`
+}
+
+func readClusters(name string) ([]cluster, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Clusters []cluster `yaml:"clusters"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(f.Clusters) == 0 {
+		return nil, errors.New("no clusters defined")
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range f.Clusters {
+		if c.Name == "" || len(c.Monitors) == 0 {
+			return nil, errors.New("each cluster needs a name and at least one monitor")
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("duplicate cluster %q", c.Name)
+		}
+		seen[c.Name] = true
+		for _, m := range c.Monitors {
+			if err := checkHost(m); err != nil {
+				return nil, fmt.Errorf("cluster %q: %v", c.Name, err)
+			}
+		}
+	}
+
+	return f.Clusters, nil
+}
+
+// readInventory reads the clusters from a dashboard inventory file as used to
+// provision the Ceph dashboards, so that the monitors do not need to be
+// maintained in a separate clusters file. Clusters are listed by name and
+// their monitors are taken from mon_host, mon_hosts, mons or monitors, given
+// either as list or as string in ceph.conf mon_host syntax:
+//
+//	clusters:
+//	  prod:
+//	    dashboard: https://prod-dashboard.example.org
+//	    mon_host: "[v2:10.0.0.1:3300,v1:10.0.0.1:6789] mon2.example.org"
+//	  lab:
+//	    mons: [labmon1]
+func readInventory(name string) ([]cluster, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Clusters map[string]map[string]interface{} `yaml:"clusters"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(f.Clusters) == 0 {
+		return nil, errors.New("no clusters defined")
+	}
+
+	var clusters []cluster
+	for n, entry := range f.Clusters {
+		c := cluster{Name: n}
+		for _, key := range []string{"mon_host", "mon_hosts", "mons", "monitors"} {
+			if v, ok := entry[key]; ok {
+				c.Monitors = append(c.Monitors, inventoryHosts(v)...)
+			}
+		}
+		if len(c.Monitors) == 0 {
+			return nil, fmt.Errorf("cluster %q has no monitors", n)
+		}
+		for _, m := range c.Monitors {
+			if err := checkHost(m); err != nil {
+				return nil, fmt.Errorf("cluster %q: %v", n, err)
+			}
+		}
+		clusters = append(clusters, c)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+
+	return clusters, nil
+}
+
+// inventoryHosts returns the monitor hosts of an inventory value, which is a
+// list of hosts or a mon_host string.
+func inventoryHosts(v interface{}) []string {
+	var hosts []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			hosts = append(hosts, inventoryHosts(e)...)
+		}
+	case string:
+		// mon_host separates monitors by spaces, commas or semicolons and
+		// groups the addresses of one monitor in brackets, e.g.
+		// [v2:10.0.0.1:3300/0,v1:10.0.0.1:6789/0],[v2:10.0.0.2:3300/0,...].
+		for _, mon := range splitOutsideBrackets(v, " \t,;") {
+			if strings.HasPrefix(mon, "[v1:") || strings.HasPrefix(mon, "[v2:") {
+				mon = splitOutsideBrackets(strings.TrimSuffix(mon[1:], "]"), ",")[0]
+			}
+			hosts = appendHost(hosts, mon)
+		}
+	}
+	return hosts
+}
+
+// splitOutsideBrackets splits s at the separator characters which are not
+// enclosed in brackets, dropping empty fields.
+func splitOutsideBrackets(s, seps string) []string {
+	var (
+		fields []string
+		depth  int
+		start  int
+	)
+	for i, r := range s {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case depth == 0 && strings.ContainsRune(seps, r):
+			if i > start {
+				fields = append(fields, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		fields = append(fields, s[start:])
+	}
+	return fields
+}
+
+// appendHost appends the host of a monitor address like v2:10.0.0.1:3300/0.
+func appendHost(hosts []string, addr string) []string {
+	addr = strings.TrimPrefix(strings.TrimPrefix(addr, "v1:"), "v2:")
+	if i := strings.LastIndex(addr, "/"); i >= 0 {
+		addr = addr[:i]
+	}
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		addr = h
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if addr == "" || contains(hosts, addr) {
+		return hosts
+	}
+	return append(hosts, addr)
+}
+
+// writeClusterSummary writes the number of clients per cluster and release as
+// CSV to w.
+func writeClusterSummary(w io.Writer, results map[string][]*Client) error {
+	var names []string
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"cluster", "release", "clients"})
+	for _, name := range names {
+		counts := make(map[Release]int)
+		for _, c := range results[name] {
+			counts[c.Release]++
+		}
+
+		var rels []Release
+		for r := range counts {
+			rels = append(rels, r)
+		}
+		sortReleases(rels)
+
+		for _, r := range rels {
+			cw.Write([]string{name, string(r), strconv.Itoa(counts[r])})
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
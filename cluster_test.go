@@ -0,0 +1,62 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadClustersRejectsOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		read func(string) ([]cluster, error)
+		yaml string
+	}{
+		{"clusters", readClusters, "clusters:\n  - name: prod\n    monitors: [mon1, '-oProxyCommand=touch /tmp/x']\n"},
+		{"clusters empty", readClusters, "clusters:\n  - name: prod\n    monitors: ['']\n"},
+		{"inventory list", readInventory, "clusters:\n  prod:\n    mons: ['-oProxyCommand=touch /tmp/x']\n"},
+		{"inventory mon_host", readInventory, "clusters:\n  prod:\n    mon_host: 'mon1,-oProxyCommand=x'\n"},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(t.TempDir(), "clusters.yaml")
+		if err := ioutil.WriteFile(name, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if cl, err := tt.read(name); err == nil {
+			t.Errorf("%s: got %v, want an error", tt.name, cl)
+		}
+	}
+}
+
+func TestInventoryHosts(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want []string
+	}{
+		{"mon1", []string{"mon1"}},
+		{"mon1,mon2 mon3;mon4", []string{"mon1", "mon2", "mon3", "mon4"}},
+		{"10.0.0.1:6789,10.0.0.2:6789", []string{"10.0.0.1", "10.0.0.2"}},
+		{"v2:10.0.0.1:3300/0,v1:10.0.0.2:6789/0", []string{"10.0.0.1", "10.0.0.2"}},
+		{"[v2:10.0.0.1:3300/0,v1:10.0.0.1:6789/0],[v2:10.0.0.2:3300/0,v1:10.0.0.2:6789/0]", []string{"10.0.0.1", "10.0.0.2"}},
+		{"[v2:10.0.0.1:3300/0,v1:10.0.0.1:6789/0] [v2:10.0.0.2:3300/0,v1:10.0.0.2:6789/0] mon3.example.org", []string{"10.0.0.1", "10.0.0.2", "mon3.example.org"}},
+		{"[v2:10.0.0.1:3300,v1:10.0.0.1:6789]", []string{"10.0.0.1"}},
+		{"[fd00::1]:6789 [fd00::2]:6789", []string{"fd00::1", "fd00::2"}},
+		{"[fd00::1]:6789,[fd00::2]:6789", []string{"fd00::1", "fd00::2"}},
+		{"[v2:[fd00::1]:3300/0,v1:[fd00::1]:6789/0],[v2:[fd00::2]:3300/0,v1:[fd00::2]:6789/0]", []string{"fd00::1", "fd00::2"}},
+		{"[fd00::1]", []string{"fd00::1"}},
+		{"fd00::1", []string{"fd00::1"}},
+		{"mon1, mon1", []string{"mon1"}},
+		{"", nil},
+		{[]interface{}{"mon1", "[fd00::2]:6789"}, []string{"mon1", "fd00::2"}},
+	}
+	for _, tt := range tests {
+		if got := inventoryHosts(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("inventoryHosts(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
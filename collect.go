@@ -0,0 +1,389 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// collector retrieves the connected clients from the monitors of a cluster.
+type collector struct {
+	// r runs the remote commands other than retrieving the sessions.
+	r   runner
+	src sessionSource
+	// fsid enables retrieving the cluster FSID.
+	fsid bool
+	// state is the session state to include (open, closed or all). Sessions
+	// in other states are dropped before removing duplicates.
+	state string
+	// images and pools are the RBD images, and pools of images, whose
+	// watchers are added to the clients.
+	images []string
+	pools  []string
+	// confirm is the delay of a second poll of the monitors. If non zero,
+	// only clients seen in both polls are kept.
+	confirm time.Duration
+	// dedup is the key sessions are deduplicated by, see Client.dedupKey.
+	dedup string
+	// resolveHosts enables resolving the monitor hosts to detect monitors
+	// given twice under different names.
+	resolveHosts bool
+	// rrPolicy is the policy for round-robin monitor names, see rrAll.
+	rrPolicy string
+	// preflight enables checking that sudo works without a password on
+	// each host before collecting, see checkSudo.
+	preflight bool
+	// wrapper is checked by the preflight instead of sudo in general.
+	wrapper string
+	// includeSelf keeps the sessions from the addresses of the monitor
+	// hosts, e.g. of colocated daemons, which are dropped otherwise.
+	includeSelf bool
+	// order, if set, orders the monitors by preference for the commands
+	// only run on the first monitor which succeeds, e.g. closestFirst.
+	order func(hosts []string) []string
+}
+
+// collection is the result of collecting the clients of a cluster.
+type collection struct {
+	clients  []*Client
+	fsid     string
+	monitors []monitorResult
+	// near is the order monitors are tried in for commands only run on one
+	// of them, see collector.order.
+	near []string
+	// entityTypes is the number of sessions per entity type (client, osd,
+	// ...) in the selected -state, before merging duplicates and filtering
+	// the clients.
+	entityTypes map[string]int
+}
+
+// Exit codes used if no clients were collected.
+const (
+	exitMonitorsFailed = 2
+	exitNoSessions     = 3
+)
+
+// failed reports whether all monitors failed.
+func (res *collection) failed() bool {
+	for _, m := range res.monitors {
+		if m.Error == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// diagnoseEmpty explains why a collection without clients is empty. The exit
+// code is zero if monitors reported sessions which were all filtered out,
+// which is a valid result.
+func (res *collection) diagnoseEmpty() (string, int) {
+	failed, sessions := 0, 0
+	for _, m := range res.monitors {
+		if m.Error != "" {
+			failed++
+		}
+		sessions += m.Sessions
+	}
+
+	switch {
+	case failed == len(res.monitors):
+		return fmt.Sprintf("no clients: all %d monitors failed", failed), exitMonitorsFailed
+	case sessions == 0:
+		return fmt.Sprintf("no clients: %d monitors reachable but no sessions parsed", len(res.monitors)-failed), exitNoSessions
+	}
+	return fmt.Sprintf("no clients: all %d sessions were filtered out", sessions), 0
+}
+
+// monitorResult holds the outcome of querying a single monitor.
+type monitorResult struct {
+	Host           string  `json:"host"`
+	Sessions       int     `json:"sessions"`
+	ConnectSeconds float64 `json:"connect_seconds,omitempty"`
+	CommandSeconds float64 `json:"command_seconds"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// collect returns the merged clients of all given monitors and, if enabled,
+// the cluster FSID. Monitors which fail are logged and skipped.
+func (col *collector) collect(hosts []string) *collection {
+	hosts, self := col.uniqueHosts(hosts)
+	var failed []monitorResult
+	if col.preflight {
+		hosts, failed = col.checkSudo(hosts)
+	}
+	res := col.poll(hosts)
+	res.monitors = append(failed, res.monitors...)
+	res.near = hosts
+	if col.order != nil {
+		res.near = col.order(hosts)
+	}
+	if col.fsid {
+		res.fsid = col.collectFSID(res)
+	}
+
+	if col.confirm > 0 {
+		time.Sleep(col.confirm)
+		second := col.poll(hosts)
+		seen := make(map[string]bool)
+		for _, c := range second.clients {
+			seen[c.dedupKey(col.dedup)] = true
+		}
+		if second.failed() {
+			log.Println("unable to confirm clients: all monitors failed in the second poll")
+			seen = nil
+		}
+
+		var confirmed []*Client
+		for _, c := range res.clients {
+			if seen != nil && !seen[c.dedupKey(col.dedup)] {
+				log.Printf("dropping client %s (%s): not seen again after %v\n", c.IP(), c.Entity, col.confirm)
+				continue
+			}
+			confirmed = append(confirmed, c)
+		}
+		res.clients = confirmed
+	}
+
+	if len(col.images) > 0 || len(col.pools) > 0 {
+		res.clients = col.collectWatchers(res.near, col.images, col.pools, res.clients)
+	}
+
+	if !col.includeSelf {
+		var filtered []*Client
+		for _, c := range res.clients {
+			if !self[c.IP()] {
+				filtered = append(filtered, c)
+			}
+		}
+		if n := len(res.clients) - len(filtered); n > 0 {
+			log.Printf("skipping %d sessions from the monitor hosts, use -include-self to include them\n", n)
+		}
+		res.clients = filtered
+	}
+
+	return res
+}
+
+// head returns at most the first n bytes of b.
+func head(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
+
+// checkSudo returns the hosts on which sudo can be used without a password,
+// or the wrapper may be run, and the results of the others. Without the
+// check, hosts lacking sudo rights fail later with sudo's prompt or error
+// text in place of the sessions.
+func (col *collector) checkSudo(hosts []string) ([]string, []monitorResult) {
+	cmd := "sudo -n true"
+	if col.wrapper != "" {
+		cmd = "sudo -n -l " + col.wrapper
+	}
+
+	var (
+		ok     []string
+		failed []monitorResult
+	)
+	for _, h := range hosts {
+		if _, err := col.r.Run(h, cmd); err != nil {
+			log.Printf("warning: skipping monitor %s, user lacks passwordless sudo ('%s' failed: %v)\n", h, cmd, err)
+			failed = append(failed, monitorResult{Host: h, Error: fmt.Sprintf("preflight: %v", err)})
+			continue
+		}
+		ok = append(ok, h)
+	}
+	return ok, failed
+}
+
+// Policies for monitor names resolving to multiple addresses of the same
+// family, e.g. a round-robin name for all monitors.
+const (
+	rrAll   = "all"   // query every address
+	rrFirst = "first" // query the first address only
+	rrName  = "name"  // pass the name on, leaving the choice to the resolver
+)
+
+// uniqueHosts returns hosts without duplicates, logging a warning for each
+// one. With resolveHosts, hosts resolving to a common address are duplicates
+// as well, and round-robin names are expanded according to the rrPolicy.
+// The addresses of the hosts, as far as known, are returned as well.
+func (col *collector) uniqueHosts(hosts []string) ([]string, map[string]bool) {
+	var (
+		unique []string
+		seen   = make(map[string]string)
+		self   = make(map[string]bool)
+	)
+	add := func(h string, keys []string) {
+		dup := ""
+		for _, k := range keys {
+			if prev, ok := seen[k]; ok {
+				dup = prev
+				break
+			}
+		}
+		if dup != "" {
+			log.Printf("warning: skipping monitor %s, it is the same host as %s\n", h, dup)
+			return
+		}
+		for _, k := range keys {
+			seen[k] = h
+		}
+		unique = append(unique, h)
+	}
+
+	for _, h := range hosts {
+		var addrs []string
+		if col.resolveHosts {
+			addrs = lookupHost(h)
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			self[ip.String()] = true
+		}
+		for _, a := range addrs {
+			self[a] = true
+		}
+
+		if rr := roundRobinAddrs(addrs); len(rr) > 1 && col.rrPolicy != rrName {
+			if col.rrPolicy == rrFirst {
+				rr = rr[:1]
+			}
+			log.Printf("monitor %s resolves to %d addresses, querying %s\n", h, len(addrs), strings.Join(rr, " "))
+			for _, a := range rr {
+				add(a, []string{a})
+			}
+			continue
+		}
+		add(h, append([]string{normalizeName(h)}, addrs...))
+	}
+	return unique, self
+}
+
+// roundRobinAddrs returns the addresses of the family with the most addresses,
+// preferring IPv4, if there are multiple of that family. A single IPv4 and
+// IPv6 address are a dual-stack host and no round-robin name.
+func roundRobinAddrs(addrs []string) []string {
+	var v4, v6 []string
+	for _, a := range addrs {
+		if strings.Contains(a, ":") {
+			v6 = append(v6, a)
+		} else {
+			v4 = append(v4, a)
+		}
+	}
+	if len(v6) > len(v4) {
+		v4 = v6
+	}
+	if len(v4) < 2 {
+		return nil
+	}
+	return v4
+}
+
+// lookupHost returns the addresses of host, or nothing if it does not
+// resolve, e.g. because it is an alias from the ssh configuration.
+func lookupHost(host string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for i, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil {
+			addrs[i] = ip.String()
+		}
+	}
+	return addrs
+}
+
+// poll queries the monitors once.
+func (col *collector) poll(hosts []string) *collection {
+	res := &collection{entityTypes: make(map[string]int)}
+	seen := make(map[string]bool)
+	sessions := make(map[string]bool)
+	for _, h := range hosts {
+		mr := monitorResult{Host: h}
+		start := time.Now()
+		out, err := col.src.sessions(h)
+		d := time.Since(start)
+		if ct, ok := col.r.(connectTimer); ok {
+			connect := ct.connectTime(h)
+			mr.ConnectSeconds = connect.Seconds()
+			d -= connect
+		}
+		mr.CommandSeconds = d.Seconds()
+		if err != nil {
+			log.Printf("unable to retrieve sessions on %s: %v\n", h, err)
+			mr.Error = err.Error()
+			res.monitors = append(res.monitors, mr)
+			continue
+		}
+
+		c, err := parseSessions(out)
+		if err != nil {
+			log.Printf("unable to unmarshal sessions: %v\n", err)
+			log.Printf("output of %s starts with %q, check for a forced command or output of shell startup files\n", h, head(out, 60))
+			mr.Error = err.Error()
+			res.monitors = append(res.monitors, mr)
+			continue
+		}
+		mr.Sessions = len(c)
+		res.monitors = append(res.monitors, mr)
+		seenAt := time.Now()
+
+		for _, add := range c {
+			if col.state != "all" && add.State != SessionState(col.state) && add.State != unknown {
+				continue
+			}
+			if k := add.dedupKey(dedupIPEntity); !sessions[k] {
+				sessions[k] = true
+				t := add.Entity.Type()
+				if t == "" {
+					t = unknown
+				}
+				res.entityTypes[t]++
+			}
+			k := add.dedupKey(col.dedup)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			add.SeenAt = seenAt
+			res.clients = append(res.clients, add)
+		}
+	}
+
+	return res
+}
+
+// collectFSID returns the cluster FSID as reported by the first monitor,
+// in the order of res.near, which did not fail to report its sessions.
+func (col *collector) collectFSID(res *collection) string {
+	failed := make(map[string]bool)
+	for _, m := range res.monitors {
+		if m.Error != "" {
+			failed[m.Host] = true
+		}
+	}
+	for _, h := range res.near {
+		if failed[h] {
+			continue
+		}
+		out, err := col.r.Run(h, "sudo ceph fsid")
+		if err != nil {
+			log.Printf("unable to execute 'ceph fsid' on %s: %v\n", h, err)
+			continue
+		}
+		return strings.TrimSpace(string(out))
+	}
+	return ""
+}
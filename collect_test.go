@@ -0,0 +1,127 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// staticSource returns the same sessions for every monitor.
+type staticSource []byte
+
+func (s staticSource) sessions(host string) ([]byte, error) {
+	return s, nil
+}
+
+// fakeSessions returns the plain sessions of n clients on distinct
+// addresses, each connected twice.
+func fakeSessions(n int) []byte {
+	var sessions []string
+	for i := 0; i < n; i++ {
+		ip := fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+		for j := 0; j < 2; j++ {
+			sessions = append(sessions, fmt.Sprintf("MonSession(client.%d %s:0/%d is open allow *, features 0x3ffddff8eea4fffb (luminous))", i, ip, j))
+		}
+	}
+	out, err := json.Marshal(sessions)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func TestPollDedup(t *testing.T) {
+	tests := []struct {
+		dedup string
+		want  int
+	}{
+		{dedupIP, 100},
+		{dedupEntity, 100},
+		{dedupIPEntity, 100},
+	}
+	for _, tt := range tests {
+		col := &collector{src: staticSource(fakeSessions(100)), state: "open", dedup: tt.dedup}
+		res := col.poll([]string{"mon1", "mon2", "mon3"})
+		if len(res.clients) != tt.want {
+			t.Errorf("dedup %s: %d clients, want %d", tt.dedup, len(res.clients), tt.want)
+		}
+		for _, m := range res.monitors {
+			if m.Sessions != 200 {
+				t.Errorf("dedup %s: monitor %s reported %d sessions, want 200", tt.dedup, m.Host, m.Sessions)
+			}
+		}
+		if res.entityTypes["client"] != 100 {
+			t.Errorf("dedup %s: %d client sessions, want 100", tt.dedup, res.entityTypes["client"])
+		}
+	}
+}
+
+func BenchmarkPoll(b *testing.B) {
+	for _, n := range []int{1000, 20000} {
+		src := staticSource(fakeSessions(n))
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			col := &collector{src: src, state: "open", dedup: dedupIP}
+			for i := 0; i < b.N; i++ {
+				col.poll([]string{"mon1", "mon2", "mon3"})
+			}
+		})
+	}
+}
+
+func BenchmarkDedupKey(b *testing.B) {
+	c := &Client{Entity: "client.84123", Addr: netip.MustParseAddr("10.7.3.67")}
+	for _, by := range []string{dedupIP, dedupEntity, dedupIPEntity} {
+		b.Run(by, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.dedupKey(by)
+			}
+		})
+	}
+}
+
+// pollSource returns the sessions of the next poll on every call.
+type pollSource struct {
+	polls [][]string
+	n     int
+}
+
+func (s *pollSource) sessions(host string) ([]byte, error) {
+	out, err := json.Marshal(s.polls[s.n])
+	s.n++
+	return out, err
+}
+
+func TestConfirmDedupKey(t *testing.T) {
+	const session = "MonSession(%s %s:0/1 is open allow *, features 0x3ffddff8eea4fffb (luminous))"
+	polls := [][]string{
+		{fmt.Sprintf(session, "client.1", "10.7.3.1"), fmt.Sprintf(session, "client.2", "10.7.3.2")},
+		// client.1 reconnected from another address
+		{fmt.Sprintf(session, "client.1", "10.7.3.9"), fmt.Sprintf(session, "client.3", "10.7.3.2")},
+	}
+	tests := []struct {
+		dedup string
+		want  []string
+	}{
+		{dedupIP, []string{"client.2"}},
+		{dedupEntity, []string{"client.1"}},
+		{dedupIPEntity, nil},
+	}
+	for _, tt := range tests {
+		col := &collector{src: &pollSource{polls: polls}, state: "open", dedup: tt.dedup, confirm: time.Nanosecond, includeSelf: true}
+		res := col.collect([]string{"mon1"})
+		var got []string
+		for _, c := range res.clients {
+			got = append(got, string(c.Entity))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dedup %s: confirmed %v, want %v", tt.dedup, got, tt.want)
+		}
+	}
+}
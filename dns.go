@@ -0,0 +1,299 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolver performs reverse DNS lookups. After maxTimeouts consecutive timed
+// out lookups it stops querying DNS altogether, so runs in networks without a
+// reachable DNS server do not time out on every single client.
+type resolver struct {
+	timeout     time.Duration
+	maxTimeouts int
+	// overrides maps IPs to names which take precedence over DNS.
+	overrides map[string]string
+	// disabled disables DNS lookups, only overrides are used.
+	disabled bool
+	// domain, if set, is appended to short names and all names are
+	// normalized, see qualifyName.
+	domain string
+	// parallel is the maximum number of lookups in flight.
+	parallel int
+	// budget limits the total time of all lookups of a run, lookups
+	// not done in time are skipped. Zero means no limit.
+	budget time.Duration
+	// overBudget is used as the name of clients whose lookup was
+	// skipped because the budget was exceeded.
+	overBudget string
+
+	mu       sync.Mutex
+	timeouts int
+}
+
+// resolveAll sets the fqdn of all clients, running up to parallel lookups at
+// once within the budget.
+func (r *resolver) resolveAll(clients []*Client) {
+	var deadline time.Time
+	if r.budget > 0 {
+		deadline = time.Now().Add(r.budget)
+	}
+	n := r.parallel
+	if n < 1 {
+		n = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, n)
+		mu      sync.Mutex
+		skipped int
+	)
+	for _, c := range clients {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			name, ok := r.lookup(c.Addr.String(), deadline)
+			if !ok {
+				name = r.overBudget
+				mu.Lock()
+				skipped++
+				mu.Unlock()
+			}
+			c.FQDN = name
+		}(c)
+	}
+	wg.Wait()
+
+	if skipped > 0 {
+		log.Printf("reverse DNS budget of %v exceeded, skipped %d lookups\n", r.budget, skipped)
+	}
+}
+
+// lookup returns the space separated names for the given IP or an empty
+// string if there are none or the lookup failed. If the lookup could not be
+// done before the deadline, false is returned. A zero deadline means no
+// deadline.
+func (r *resolver) lookup(ip string, deadline time.Time) (string, bool) {
+	if name, ok := r.overrides[ip]; ok {
+		return r.normalize(name), true
+	}
+	if r.disabled {
+		return "", true
+	}
+	r.mu.Lock()
+	gaveUp := r.maxTimeouts > 0 && r.timeouts >= r.maxTimeouts
+	r.mu.Unlock()
+	if gaveUp {
+		return "", true
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if !deadline.IsZero() {
+		var cancelBudget context.CancelFunc
+		ctx, cancelBudget = context.WithDeadline(ctx, deadline)
+		defer cancelBudget()
+	}
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return "", false
+		}
+		if e, ok := err.(*net.DNSError); ok && e.IsTimeout {
+			r.mu.Lock()
+			r.timeouts++
+			if r.timeouts == r.maxTimeouts {
+				log.Printf("%d consecutive reverse DNS timeouts, skipping remaining lookups\n", r.timeouts)
+			}
+			r.mu.Unlock()
+		}
+		return "", true
+	}
+	r.mu.Lock()
+	r.timeouts = 0
+	r.mu.Unlock()
+
+	return r.normalize(strings.Join(names, " ")), true
+}
+
+// normalize normalizes the space separated names if a domain is configured.
+func (r *resolver) normalize(names string) string {
+	if r.domain == "" {
+		return names
+	}
+	fields := strings.Fields(names)
+	for i, n := range fields {
+		fields[i] = qualifyName(n, r.domain)
+	}
+	return strings.Join(fields, " ")
+}
+
+// qualifyName returns name in lower case as fully qualified name with a
+// trailing dot. Short names, e.g. returned by PTR records relative to the
+// wrong origin, are qualified with domain.
+func qualifyName(name, domain string) string {
+	name = normalizeName(name)
+	if !strings.Contains(name, ".") {
+		name += "." + normalizeName(strings.TrimPrefix(domain, "."))
+	}
+	return name + "."
+}
+
+// readHostsFile reads a file in /etc/hosts format (IP followed by one or more
+// names, # starts a comment) and returns the space separated names by IP.
+func readHostsFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hosts := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil || len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected an IP followed by names", name, n)
+		}
+		hosts[ip.String()] = strings.Join(fields[1:], " ")
+	}
+	return hosts, s.Err()
+}
+
+// readPTRCache reads pre-fetched reverse DNS names, either in /etc/hosts
+// format or as reverse zone dump with PTR records as printed by 'dig axfr',
+// and returns the space separated names by IP.
+func readPTRCache(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[string][]string)
+	origin, owner := "", ""
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "$ORIGIN" && len(fields) == 2 {
+			origin = fields[1]
+			continue
+		}
+		if strings.HasPrefix(fields[0], "$") {
+			// Other directives, e.g. $TTL.
+			continue
+		}
+
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%s:%d: expected an IP followed by names", name, n)
+			}
+			names[ip.String()] = append(names[ip.String()], fields[1:]...)
+			continue
+		}
+
+		// Records starting with white space belong to the previous owner.
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			owner = fields[0]
+		}
+		ptr := -1
+		for i, f := range fields {
+			if strings.EqualFold(f, "PTR") {
+				ptr = i
+				break
+			}
+		}
+		if ptr < 0 {
+			// Other records of the zone, e.g. SOA and NS.
+			continue
+		}
+		if ptr+1 >= len(fields) || owner == "" {
+			return nil, fmt.Errorf("%s:%d: incomplete PTR record", name, n)
+		}
+		rev := owner
+		switch {
+		case rev == "@":
+			rev = origin
+		case !strings.HasSuffix(rev, "."):
+			rev += "." + origin
+		}
+		ip, err := reverseIP(rev)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		names[ip.String()] = append(names[ip.String()], fields[ptr+1])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	cache := make(map[string]string, len(names))
+	for ip, n := range names {
+		cache[ip] = strings.Join(n, " ")
+	}
+	return cache, nil
+}
+
+// reverseIP returns the IP of a name in the in-addr.arpa or ip6.arpa zone.
+func reverseIP(name string) (net.IP, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) == 4 {
+			if ip := net.ParseIP(labels[3] + "." + labels[2] + "." + labels[1] + "." + labels[0]); ip != nil {
+				return ip, nil
+			}
+		}
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(labels) == 32 {
+			var b strings.Builder
+			for i := 31; i >= 0; i-- {
+				b.WriteString(labels[i])
+				if i%4 == 0 && i > 0 {
+					b.WriteString(":")
+				}
+			}
+			if ip := net.ParseIP(b.String()); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("not a reverse DNS name: %q", name)
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadPTRCache(t *testing.T) {
+	cache, err := readPTRCache("testdata/reverse.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"10.2.3.67":      "gw1.example.org. s3.example.org.",
+		"10.2.3.68":      "node1.example.org.",
+		"10.2.3.69":      "node2.example.org.",
+		"2001:db8::1":    "node1.example.org.",
+		"2001:db8:1::ba": "node3.example.org.",
+	}
+	if !reflect.DeepEqual(cache, want) {
+		t.Errorf("readPTRCache =\n%v\nwant\n%v", cache, want)
+	}
+}
+
+func TestReadPTRCacheErrors(t *testing.T) {
+	for _, content := range []string{
+		"10.2.3.67\n",
+		"$ORIGIN 3.2.10.in-addr.arpa.\n67 IN PTR\n",
+		"67.3.2.10.in-addr.arpa. IN PTR gw1.example.org.\n68 IN PTR node1.example.org.\n",
+		"$ORIGIN 2.10.in-addr.arpa.\n67 IN PTR gw1.example.org.\n",
+		"$ORIGIN 8.b.d.0.1.0.0.2.ip6.arpa.\n1.0.0 IN PTR node1.example.org.\n",
+	} {
+		name := filepath.Join(t.TempDir(), "zone")
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if cache, err := readPTRCache(name); err == nil {
+			t.Errorf("readPTRCache(%q) = %v, want error", content, cache)
+		}
+	}
+}
+
+func TestReverseIP(t *testing.T) {
+	for name, want := range map[string]string{
+		"67.3.2.10.in-addr.arpa.": "10.2.3.67",
+		"67.3.2.10.IN-ADDR.ARPA":  "10.2.3.67",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.": "2001:db8::1",
+	} {
+		ip, err := reverseIP(name)
+		if err != nil || ip.String() != want {
+			t.Errorf("reverseIP(%q) = %v, %v, want %s", name, ip, err, want)
+		}
+	}
+	for _, name := range []string{
+		"3.2.10.in-addr.arpa.",
+		"x.3.2.10.in-addr.arpa.",
+		"1.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"node1.example.org.",
+	} {
+		if ip, err := reverseIP(name); err == nil {
+			t.Errorf("reverseIP(%q) = %v, want error", name, ip)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dumpRunner saves the raw output of every command run by the wrapped runner
+// to a directory, so parsing failures can be reproduced from the exact bytes
+// a monitor returned. Files are named <host>_<time>_<n>_<command>.out, failed
+// commands are saved with the error as .err file.
+type dumpRunner struct {
+	r   runner
+	dir string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (d *dumpRunner) Run(host, cmd string) ([]byte, error) {
+	out, err := d.r.Run(host, cmd)
+
+	d.mu.Lock()
+	d.n++
+	n := d.n
+	d.mu.Unlock()
+
+	name := fmt.Sprintf("%s_%s_%03d_%s", slug(host), time.Now().Format("20060102T150405.000"), n, slug(cmd))
+	data, ext := out, ".out"
+	if err != nil {
+		data, ext = []byte(fmt.Sprintf("%s\n%v\n", cmd, err)), ".err"
+	}
+	if werr := ioutil.WriteFile(filepath.Join(d.dir, name+ext), data, 0644); werr != nil {
+		log.Printf("unable to dump output of '%s' on %s: %v\n", cmd, host, werr)
+	}
+
+	return out, err
+}
+
+func (d *dumpRunner) connectTime(host string) time.Duration {
+	if ct, ok := d.r.(connectTimer); ok {
+		return ct.connectTime(host)
+	}
+	return 0
+}
+
+// slug returns s usable as part of a file name, replacing everything but
+// letters, digits, dots and dashes and limiting the length.
+func slug(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+	s = strings.Trim(s, "._")
+	if len(s) > 100 {
+		s = s[:100]
+	}
+	return s
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// TestEndToEnd runs ceph-get-clients against the fake monitor of
+// internal/fakemon.
+func TestEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end to end test in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "ceph-get-clients")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	host, port := startFakemon(t)
+	sock := startAgent(t, dir)
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		want   []string
+	}{
+		{
+			name: "csv",
+			want: []string{
+				"IP,feature,release,fqdn,domain,family,entity,global_id,global_id_status,state,kind,implementation,mixed_release,msgr\n",
+				"10.7.3.67,0x3ffddff8eea4fffb,luminous,,,ipv4,client.84123,84123,,open,librados,userspace,false,v1\n",
+				"10.7.3.64,0x7010fb86aa42ada,jewel,,,ipv4,client.73002,73002,,open,kernel,kernel,false,v1\n",
+				"10.7.3.80,0x3f01cfb8ffedffff,luminous,,,ipv4,client.rgw.gw1,5001,reclaim_insecure,open,rgw,userspace,false,v2\n",
+			},
+		},
+		{
+			name: "fsid",
+			args: []string{"-fsid"},
+			want: []string{",open,daemon,userspace,false,v1,7b1c3b5e-0e4b-4a8e-9d3c-2f6a5d1e0c42\n"},
+		},
+		{
+			name: "feature",
+			args: []string{"-feature", "0x7010fb86aa42ada", "-kind", "kernel"},
+			want: []string{",kernel,kernel,false,v1,true\n"},
+		},
+		{
+			name: "ansible-inventory",
+			args: []string{"-output", "ansible-inventory"},
+			want: []string{"    release_jewel:\n      hosts:\n        10.7.3.64:\n          ceph_release: jewel\n"},
+		},
+		{
+			name:   "expect-max",
+			args:   []string{"-expect-max", "1"},
+			status: exitUnexpected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-user", "test", "-port", port, "-no-dns"}, tt.args...)
+			cmd := exec.Command(bin, append(args, host)...)
+			cmd.Env = append(os.Environ(), "SSH_AUTH_SOCK="+sock)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			out, err := cmd.Output()
+
+			status := 0
+			if exit, ok := err.(*exec.ExitError); ok {
+				status = exit.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.status {
+				t.Fatalf("exit status %d, want %d\n%s", status, tt.status, stderr.String())
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("output does not contain %q:\n%s", w, out)
+				}
+			}
+		})
+	}
+}
+
+// startFakemon starts the fake monitor listening on a random port using
+// 'go run' and returns its address. It is stopped when the test finishes.
+func startFakemon(t *testing.T) (host, port string) {
+	t.Helper()
+
+	cmd := exec.Command("go", "run", "./internal/fakemon", "-listen", "127.0.0.1:0", "-stdin")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdin.Close()
+		cmd.Wait()
+	})
+
+	// Wait for the address it listens on and keep draining its log.
+	s := bufio.NewScanner(stderr)
+	for s.Scan() {
+		i := strings.Index(s.Text(), "listening on ")
+		if i < 0 {
+			continue
+		}
+		go io.Copy(ioutil.Discard, stderr)
+		host, port, err := net.SplitHostPort(s.Text()[i+len("listening on "):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return host, port
+	}
+	t.Fatalf("fakemon did not start: %v", s.Err())
+	return "", ""
+}
+
+// startAgent serves an SSH agent holding a new key on a socket in dir and
+// returns the socket path.
+func startAgent(t *testing.T, dir string) string {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	return sock
+}
@@ -0,0 +1,29 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+// TestExamples makes sure the example files in examples/ stay valid.
+func TestExamples(t *testing.T) {
+	if cl, err := readClusters("examples/clusters.yaml"); err != nil || len(cl) != 2 {
+		t.Errorf("clusters.yaml: %d clusters, %v", len(cl), err)
+	}
+	if cl, err := readInventory("examples/inventory.yaml"); err != nil || len(cl) != 2 {
+		t.Errorf("inventory.yaml: %d clusters, %v", len(cl), err)
+	}
+	if _, err := readAllowlist("examples/allowlist.yaml"); err != nil {
+		t.Errorf("allowlist.yaml: %v", err)
+	}
+	if _, err := readRoles("examples/roles.yaml"); err != nil {
+		t.Errorf("roles.yaml: %v", err)
+	}
+	if _, err := readAnnotations("examples/annotations.yaml"); err != nil {
+		t.Errorf("annotations.yaml: %v", err)
+	}
+	if _, err := readDomainOwners("examples/domain-owners.yaml"); err != nil {
+		t.Errorf("domain-owners.yaml: %v", err)
+	}
+}
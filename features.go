@@ -0,0 +1,112 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// featureInfo describes a Ceph feature bit (or set of bits) and why it
+// matters.
+type featureInfo struct {
+	Name        string `json:"name"`
+	Mask        string `json:"mask"`
+	Description string `json:"description"`
+}
+
+// knownFeatures is a short list of features which commonly gate cluster
+// settings, see src/include/ceph_features.h. It can be extended or updated at
+// runtime using -feature-db.
+var knownFeatures = []featureInfo{
+	{"crush_tunables", "0x40000", "CRUSH tunables (argonaut). Required by 'ceph osd crush tunables argonaut' and newer profiles."},
+	{"upmap", "0x200000", "pg-upmap and CRUSH choose_args (luminous). Required by the upmap balancer mode; enable with 'ceph osd set-require-min-compat-client luminous'."},
+	{"crush_tunables2", "0x2000000", "CRUSH tunables2 (bobtail). Required by the bobtail tunables profile."},
+	{"crush_tunables3", "0x20000000000", "CRUSH tunables3, chooseleaf_vary_r (firefly). Required by the firefly tunables profile."},
+	{"crush_v4", "0x1000000000000", "CRUSH straw2 buckets (hammer). Required once any bucket uses the straw2 algorithm."},
+	{"crush_tunables5", "0x400000000000000", "CRUSH tunables5, chooseleaf_stable (jewel). Required by the jewel and optimal tunables profiles."},
+	{"fs_btime", "0x800000000000000", "CephFS birth time and change attribute (jewel). The bit is shared with MSG_ADDR2 and set by jewel clients already, it does not indicate messenger v2 support."},
+}
+
+// lookupFeature returns the information for the feature with the given mask.
+func lookupFeature(mask string) (featureInfo, bool) {
+	m, err := strconv.ParseUint(trimHexPrefix(mask), 16, 64)
+	if err != nil {
+		return featureInfo{}, false
+	}
+	for _, f := range knownFeatures {
+		if fm, err := strconv.ParseUint(trimHexPrefix(f.Mask), 16, 64); err == nil && fm == m {
+			return f, true
+		}
+	}
+	return featureInfo{}, false
+}
+
+// loadFeatureDB reads a JSON array of features from the named file. Features
+// replace known features with the same name and are added otherwise.
+func loadFeatureDB(name string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var db []featureInfo
+	if err := json.Unmarshal(b, &db); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	for _, f := range db {
+		if _, err := strconv.ParseUint(trimHexPrefix(f.Mask), 16, 64); err != nil || f.Name == "" {
+			return fmt.Errorf("%s: feature %q needs a name and a hex mask", name, f.Name)
+		}
+
+		replaced := false
+		for i, k := range knownFeatures {
+			if k.Name == f.Name {
+				knownFeatures[i] = f
+				replaced = true
+			}
+		}
+		if !replaced {
+			knownFeatures = append(knownFeatures, f)
+		}
+	}
+	return nil
+}
+
+// resolveFeature returns the hex mask for s, which is either a hex mask or the
+// name of a known feature. Multiple features can be combined separated by
+// commas, e.g. upmap,crush_v4.
+func resolveFeature(s string) (string, error) {
+	if !strings.Contains(s, ",") {
+		return resolveSingleFeature(s)
+	}
+
+	var mask uint64
+	for _, f := range strings.Split(s, ",") {
+		m, err := resolveSingleFeature(strings.TrimSpace(f))
+		if err != nil {
+			return "", err
+		}
+		v, _ := strconv.ParseUint(trimHexPrefix(m), 16, 64)
+		mask |= v
+	}
+	return "0x" + strconv.FormatUint(mask, 16), nil
+}
+
+func resolveSingleFeature(s string) (string, error) {
+	if _, err := strconv.ParseUint(trimHexPrefix(s), 16, 64); err == nil {
+		return s, nil
+	}
+	for _, f := range knownFeatures {
+		if strings.EqualFold(f.Name, s) {
+			return f.Mask, nil
+		}
+	}
+	return "", fmt.Errorf("unknown feature %q", s)
+}
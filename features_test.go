@@ -0,0 +1,53 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestResolveFeature(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0x200000", want: "0x200000"},
+		{in: "200000", want: "200000"},
+		{in: "upmap", want: "0x200000"},
+		{in: "UPMAP", want: "0x200000"},
+		{in: "upmap,crush_v4", want: "0x1000000200000"},
+		{in: "upmap, crush_v4", want: "0x1000000200000"},
+		{in: "crush_v4,upmap", want: "0x1000000200000"},
+		{in: "upmap,upmap", want: "0x200000"},
+		{in: "upmap,0x40000", want: "0x240000"},
+		{in: "0x200000,0x1000000000000", want: "0x1000000200000"},
+		{in: "upmap,crush_tunables5,fs_btime", want: "0xc00000000200000"},
+		{in: "unknown", wantErr: true},
+		{in: "upmap,unknown", wantErr: true},
+		{in: "upmap,", wantErr: true},
+		{in: ",", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveFeature(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveFeature(%q) error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveFeature(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLookupFeature(t *testing.T) {
+	for _, mask := range []string{"0x200000", "200000", "0x0000200000"} {
+		if f, ok := lookupFeature(mask); !ok || f.Name != "upmap" {
+			t.Errorf("lookupFeature(%q) = %q, %v, want upmap", mask, f.Name, ok)
+		}
+	}
+	if f, ok := lookupFeature("0x1000000200000"); ok {
+		t.Errorf("lookupFeature of a combined mask = %q, want none", f.Name)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A filter reports whether a client should be included in the output.
+type filter func(c *Client) bool
+
+// parseFilter parses a filter expression. An expression compares output
+// columns with string literals and combines comparisons using &&, || and !,
+// grouped by parentheses:
+//
+//	release == "jewel" && fqdn endswith ".lab.tld."
+//	!(kind == "daemon") || release < "luminous"
+//
+// Supported operators are ==, !=, <, <=, >, >=, contains, startswith and
+// endswith. The release column is ordered by release history, other columns
+// are compared as numbers if both sides are numbers and as strings otherwise.
+func parseFilter(expr string, cols []column) (filter, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{toks: toks, cols: cols}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, errors.New("unterminated string")
+			}
+			str, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{tokString, str})
+			i = j + 1
+		case strings.ContainsRune("()!=<>&|", r):
+			op := string(r)
+			if i+1 < len(s) {
+				if two := s[i : i+2]; two == "&&" || two == "||" || two == "==" || two == "!=" || two == "<=" || two == ">=" {
+					op = two
+				}
+			}
+			if op == "&" || op == "|" || op == "=" {
+				return nil, fmt.Errorf("unknown operator %q", op)
+			}
+			toks = append(toks, token{tokOp, op})
+			i += len(op)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokIdent, s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return toks, nil
+}
+
+type filterParser struct {
+	toks []token
+	pos  int
+	cols []column
+}
+
+func (p *filterParser) peek(text string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind != tokString && p.toks[p.pos].text == text
+}
+
+func (p *filterParser) or() (filter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *Client) bool { return l(c) || right(c) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (filter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(c *Client) bool { return l(c) && right(c) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) unary() (filter, error) {
+	switch {
+	case p.peek("!"):
+		p.pos++
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(c *Client) bool { return !f(c) }, nil
+	case p.peek("("):
+		p.pos++
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return f, nil
+	}
+	return p.comparison()
+}
+
+func (p *filterParser) comparison() (filter, error) {
+	if p.pos+2 >= len(p.toks) {
+		return nil, errors.New("incomplete comparison")
+	}
+	field, op, lit := p.toks[p.pos], p.toks[p.pos+1], p.toks[p.pos+2]
+	if field.kind != tokIdent {
+		return nil, fmt.Errorf("expected column name, got %q", field.text)
+	}
+	if lit.kind != tokString {
+		return nil, fmt.Errorf("expected string literal after %s %s", field.text, op.text)
+	}
+	p.pos += 3
+
+	i := columnIndex(p.cols, field.text)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown column %q", field.text)
+	}
+	value := p.cols[i].value
+	want := lit.text
+
+	cmp := compareStrings
+	if strings.EqualFold(field.text, "release") {
+		cmp = compareReleases
+	}
+
+	switch op.text {
+	case "==":
+		return func(c *Client) bool { return value(c) == want }, nil
+	case "!=":
+		return func(c *Client) bool { return value(c) != want }, nil
+	case "<":
+		return func(c *Client) bool { return cmp(value(c), want) < 0 }, nil
+	case "<=":
+		return func(c *Client) bool { return cmp(value(c), want) <= 0 }, nil
+	case ">":
+		return func(c *Client) bool { return cmp(value(c), want) > 0 }, nil
+	case ">=":
+		return func(c *Client) bool { return cmp(value(c), want) >= 0 }, nil
+	case "contains":
+		return func(c *Client) bool { return strings.Contains(value(c), want) }, nil
+	case "startswith":
+		return func(c *Client) bool { return strings.HasPrefix(value(c), want) }, nil
+	case "endswith":
+		return func(c *Client) bool { return strings.HasSuffix(value(c), want) }, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op.text)
+}
+
+func compareReleases(a, b string) int {
+	return parseRelease(a).Compare(parseRelease(b))
+}
+
+func compareStrings(a, b string) int {
+	fa, erra := strconv.ParseFloat(a, 64)
+	fb, errb := strconv.ParseFloat(b, 64)
+	if erra == nil && errb == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
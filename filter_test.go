@@ -0,0 +1,119 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func filterClients() []*Client {
+	return []*Client{
+		{Addr: netip.MustParseAddr("10.7.3.64"), Release: "jewel", FQDN: "old.lab.tld.", Entity: "client.1", GlobalID: 1},
+		{Addr: netip.MustParseAddr("10.7.3.67"), Release: "luminous", FQDN: "vm.prod.tld.", Entity: "client.20", GlobalID: 20},
+		{Addr: netip.MustParseAddr("10.7.3.10"), Release: "luminous", FQDN: "osd1.lab.tld.", Entity: "osd.1"},
+		{Addr: netip.MustParseAddr("10.7.3.80"), Release: "nautilus", FQDN: `q"uote.tld.`, Entity: "client.rgw.gw1", GlobalID: 5001},
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string // matching clients by index
+	}{
+		{`release == "jewel"`, "0"},
+		{`release != "jewel"`, "123"},
+		{`release < "luminous"`, "0"},
+		{`release <= "luminous"`, "012"},
+		{`release > "luminous"`, "3"},
+		{`release >= "LUMINOUS"`, "123"},
+		{`fqdn endswith ".lab.tld."`, "02"},
+		{`fqdn startswith "vm."`, "1"},
+		{`entity contains "rgw"`, "3"},
+		{`fqdn == "q\"uote.tld."`, "3"},
+		{`global_id > "5"`, "13"},
+		// an empty value is compared as string
+		{`global_id < "100"`, "012"},
+		// numbers compare numerically, strings lexically
+		{`global_id >= "20"`, "13"},
+		{`entity > "client.2"`, "123"},
+		// && binds tighter than ||
+		{`release == "jewel" || release == "luminous" && fqdn endswith ".lab.tld."`, "02"},
+		{`(release == "jewel" || release == "luminous") && fqdn endswith ".prod.tld."`, "1"},
+		{`!(release == "luminous")`, "03"},
+		{`!release == "luminous"`, "03"},
+		{`!!(release == "jewel")`, "0"},
+		{`  release=="jewel"&&fqdn contains "old"  `, "0"},
+		{`kind == "daemon" || release < "luminous" || IP == "10.7.3.80"`, "023"},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.expr, defaultColumns())
+		if err != nil {
+			t.Errorf("parseFilter(%s): %v", tt.expr, err)
+			continue
+		}
+		got := ""
+		for i, c := range filterClients() {
+			if f(c) {
+				got += string(rune('0' + i))
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: matches %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`release`,
+		`release ==`,
+		`release == jewel`,
+		`release = "jewel"`,
+		`release === "jewel"`,
+		`release == "jewel`,
+		`release == "\q"`,
+		`release ~ "jewel"`,
+		`release like "jewel"`,
+		`"jewel" == release`,
+		`nosuchcolumn == "x"`,
+		`release == "jewel" &&`,
+		`release == "jewel" & fqdn == "x"`,
+		`release == "jewel" | fqdn == "x"`,
+		`(release == "jewel"`,
+		`release == "jewel")`,
+		`()`,
+		`!`,
+		`release == "jewel" "luminous"`,
+	} {
+		if _, err := parseFilter(expr, defaultColumns()); err == nil {
+			t.Errorf("parseFilter(%s) succeeded, want an error", expr)
+		}
+	}
+}
+
+func FuzzParseFilter(f *testing.F) {
+	for _, s := range []string{
+		`release == "jewel" && fqdn endswith ".lab.tld."`,
+		`!(kind == "daemon") || release < "luminous"`,
+		`fqdn == "q\"uote"`,
+		`((IP startswith "10."))`,
+		`global_id >= "1e3"`,
+	} {
+		f.Add(s)
+	}
+	cols := defaultColumns()
+	clients := filterClients()
+	f.Fuzz(func(t *testing.T, expr string) {
+		fl, err := parseFilter(expr, cols)
+		if err != nil {
+			return
+		}
+		for _, c := range clients {
+			fl(c)
+		}
+	})
+}
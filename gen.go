@@ -0,0 +1,131 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// writeGenerated writes the man page (man) or bash completion (bash) generated
+// from the flag definitions to w.
+func writeGenerated(w io.Writer, kind string) error {
+	switch kind {
+	case "man":
+		return writeManPage(w, flag.CommandLine)
+	case "bash":
+		return writeBashCompletion(w, flag.CommandLine)
+	}
+	return fmt.Errorf("unknown kind %q, expected man or bash", kind)
+}
+
+// writeManPage writes a man page in roff format listing the flags of fs.
+func writeManPage(w io.Writer, fs *flag.FlagSet) error {
+	var b strings.Builder
+	b.WriteString(`.TH CEPH-GET-CLIENTS 1
+.SH NAME
+ceph-get-clients \- list the clients connected to a Ceph cluster
+.SH SYNOPSIS
+.B ceph-get-clients
+[\fIoptions\fR] \fImon\fR...
+.br
+.B ceph-get-clients gen
+\fBman\fR|\fBbash\fR
+.SH DESCRIPTION
+ceph-get-clients connects to the given Ceph monitors using SSH, retrieves the
+sessions of all connected clients using 'ceph daemon mon.<id> sessions' and
+prints the merged clients with their features and release as CSV.
+.PP
+The gen subcommand writes this man page (man) or a bash completion script
+(bash) to the standard output.
+.SH OPTIONS
+`)
+	fs.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		b.WriteString(".TP\n.B \\-" + roffEscape(f.Name))
+		if name != "" {
+			b.WriteString(" \\fI" + roffEscape(name) + "\\fR")
+		}
+		b.WriteString("\n" + roffEscape(usage))
+		if !isZeroDefault(f) {
+			b.WriteString(" (default " + roffEscape(f.DefValue) + ")")
+		}
+		b.WriteString("\n")
+	})
+	b.WriteString(`.SH EXIT STATUS
+0 on success, 1 on errors or if clients do not comply with the -allowlist, 2
+if all monitors failed, 3 if no sessions could be parsed and 4 if more clients
+than allowed by -expect-max were reported.
+`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// roffEscape escapes backslashes and dashes and protects lines starting with
+// a control character.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, ".") || strings.HasPrefix(l, "'") {
+			lines[i] = `\&` + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeBashCompletion writes a bash completion script completing the flags of
+// fs and file names as their values.
+func writeBashCompletion(w io.Writer, fs *flag.FlagSet) error {
+	var names, values []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+		if !isBoolFlag(f) {
+			values = append(values, "-"+f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	_, err := fmt.Fprintf(w, `# bash completion for ceph-get-clients
+_ceph_get_clients() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	if [[ $COMP_CWORD == 2 && $prev == gen ]]; then
+		COMPREPLY=($(compgen -W "man bash" -- "$cur"))
+		return
+	fi
+	case $prev in
+	%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	esac
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	elif [[ $COMP_CWORD == 1 ]]; then
+		COMPREPLY=($(compgen -W "gen" -A hostname -- "$cur"))
+	else
+		COMPREPLY=($(compgen -A hostname -- "$cur"))
+	fi
+}
+complete -F _ceph_get_clients ceph-get-clients
+`, strings.Join(values, "|"), strings.Join(names, " "))
+	return err
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func isZeroDefault(f *flag.Flag) bool {
+	switch f.DefValue {
+	case "", "false", "0", "0s":
+		return true
+	}
+	return false
+}
@@ -1,5 +1,8 @@
 module github.com/euracresearch/ceph-get-clients
 
-go 1.15
+go 1.18
 
-require golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+require (
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	gopkg.in/yaml.v3 v3.0.1
+)
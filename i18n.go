@@ -0,0 +1,85 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// messages translates the texts of the human readable outputs (summary,
+// report and table header), keyed by the English format string. A nil
+// messages keeps the English texts.
+type messages map[string]string
+
+// text returns the translation of s, or s if there is none.
+func (m messages) text(s string) string {
+	if t, ok := m[s]; ok {
+		return t
+	}
+	return s
+}
+
+// translations holds the messages of the supported languages other than
+// English.
+var translations = map[string]messages{
+	"de": {
+		// summary
+		"clients: %d\n":                           "Clients: %d\n",
+		"role %s: %d clients\n":                   "Rolle %s: %d Clients\n",
+		"feature %s: %d of %d clients (%.1f%%)\n": "Feature %s: %d von %d Clients (%.1f%%)\n",
+		"%srelease %s: %d\n":                      "%sRelease %s: %d\n",
+		"sessions: %d\n":                          "Sitzungen: %d\n",
+		"  entity %s: %d\n":                       "  Entität %s: %d\n",
+		"msgr2 secure mode: %d of %d clients (%.1f%%) without msgr2 support could not connect\n": "msgr2 Secure Mode: %d von %d Clients (%.1f%%) ohne msgr2-Unterstützung könnten sich nicht verbinden\n",
+		"  connected using v1 despite msgr2 support: %d\n":                                       "  trotz msgr2-Unterstützung über v1 verbunden: %d\n",
+		"  unknown release: %d\n":       "  unbekanntes Release: %d\n",
+		"baseline: %d clients\n":        "Ausgangsstand: %d Clients\n",
+		"upgraded since baseline: %d\n": "seit Ausgangsstand aktualisiert: %d\n",
+		"remaining below %s: %d\n":      "verbleibend unter %s: %d\n",
+		"new since baseline: %d\n":      "seit Ausgangsstand neu: %d\n",
+		"gone since baseline: %d\n":     "seit Ausgangsstand entfernt: %d\n",
+
+		// report
+		"%d clients":                    "%d Clients",
+		" (listed below)":               " (unten aufgeführt)",
+		"%d without PTR records":        "%d ohne PTR-Eintrag",
+		"%d of %d monitors unreachable": "%d von %d Monitoren nicht erreichbar",
+		"all %d monitors reachable":     "alle %d Monitore erreichbar",
+
+		// table header
+		"feature":          "Feature",
+		"release":          "Release",
+		"fqdn":             "FQDN",
+		"domain":           "Domäne",
+		"family":           "Adressfamilie",
+		"entity":           "Entität",
+		"global_id":        "Global-ID",
+		"global_id_status": "Global-ID-Status",
+		"state":            "Zustand",
+		"kind":             "Art",
+		"implementation":   "Implementierung",
+		"mixed_release":    "gemischtes Release",
+		"msgr":             "Messenger",
+	},
+}
+
+// lookupMessages returns the messages of the language lang, nil for English.
+func lookupMessages(lang string) (messages, error) {
+	if lang == "" || lang == "en" {
+		return nil, nil
+	}
+	m, ok := translations[lang]
+	if !ok {
+		langs := []string{"en"}
+		for l := range translations {
+			langs = append(langs, l)
+		}
+		sort.Strings(langs)
+		return nil, fmt.Errorf("unsupported language %q, supported are %s", lang, strings.Join(langs, ", "))
+	}
+	return m, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// monitor is a monitor of the monmap.
+type monitor struct {
+	Name     string
+	Addr     string
+	InQuorum bool
+}
+
+// quorumStatus is the part of the output of 'ceph quorum_status' listing the
+// monitors.
+type quorumStatus struct {
+	QuorumNames []string `json:"quorum_names"`
+	MonMap      struct {
+		Mons []struct {
+			Name       string `json:"name"`
+			PublicAddr string `json:"public_addr"`
+		} `json:"mons"`
+	} `json:"monmap"`
+}
+
+// discoverMonitors returns the monitors of the monmap, asking the first of the
+// hosts which answers.
+func discoverMonitors(r runner, hosts []string) ([]monitor, error) {
+	const cmd = "sudo ceph quorum_status --format json"
+
+	var lastErr error
+	for _, h := range hosts {
+		out, err := r.Run(h, cmd)
+		if err != nil {
+			lastErr = fmt.Errorf("unable to execute '%s' on %s: %v", cmd, h, err)
+			continue
+		}
+
+		var qs quorumStatus
+		if err := json.Unmarshal(out, &qs); err != nil {
+			return nil, fmt.Errorf("unable to parse quorum status of %s: %v", h, err)
+		}
+		quorum := make(map[string]bool)
+		for _, n := range qs.QuorumNames {
+			quorum[n] = true
+		}
+		var mons []monitor
+		for _, m := range qs.MonMap.Mons {
+			if err := checkHost(m.Name); err != nil {
+				return nil, fmt.Errorf("monmap of %s: %v", h, err)
+			}
+			mons = append(mons, monitor{Name: m.Name, Addr: m.PublicAddr, InQuorum: quorum[m.Name]})
+		}
+		if len(mons) == 0 {
+			return nil, fmt.Errorf("no monitors in the monmap of %s", h)
+		}
+		return mons, nil
+	}
+	return nil, lastErr
+}
+
+// selectMonitors lists the monitors on w and reads the numbers of the monitors
+// to query from in. An empty answer selects all monitors in quorum.
+func selectMonitors(in io.Reader, w io.Writer, mons []monitor) ([]string, error) {
+	var def []string
+	for i, m := range mons {
+		if err := checkHost(m.Name); err != nil {
+			return nil, err
+		}
+		state := "in quorum"
+		if !m.InQuorum {
+			state = "out of quorum"
+		}
+		fmt.Fprintf(w, "%3d) %s %s (%s)\n", i+1, m.Name, m.Addr, state)
+		if m.InQuorum {
+			def = append(def, m.Name)
+		}
+	}
+	fmt.Fprint(w, "Monitors to query, e.g. 1,3 [all in quorum]: ")
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	fields := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' })
+	if len(fields) == 0 {
+		if len(def) == 0 {
+			return nil, errors.New("no monitor in quorum")
+		}
+		return def, nil
+	}
+
+	var hosts []string
+	for _, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 || n > len(mons) {
+			return nil, fmt.Errorf("invalid selection %q", f)
+		}
+		hosts = append(hosts, mons[n-1].Name)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// staticRunner returns the same output for every command.
+type staticRunner string
+
+func (r staticRunner) Run(host, cmd string) ([]byte, error) {
+	return []byte(r), nil
+}
+
+func TestSelectMonitors(t *testing.T) {
+	mons := []monitor{
+		{Name: "a", Addr: "10.7.3.1:6789/0", InQuorum: true},
+		{Name: "b", Addr: "10.7.3.2:6789/0"},
+		{Name: "c", Addr: "10.7.3.3:6789/0", InQuorum: true},
+	}
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "\n", want: []string{"a", "c"}},
+		{in: "", want: []string{"a", "c"}},
+		{in: "2\n", want: []string{"b"}},
+		{in: "1, 2\n", want: []string{"a", "b"}},
+		{in: "4\n", wantErr: true},
+		{in: "x\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := selectMonitors(strings.NewReader(tt.in), ioutil.Discard, mons)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectMonitors(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	evil := append(mons, monitor{Name: "-oProxyCommand=touch /tmp/x", InQuorum: true})
+	if got, err := selectMonitors(strings.NewReader("\n"), ioutil.Discard, evil); err == nil {
+		t.Errorf("selectMonitors with an option as name = %v, want an error", got)
+	}
+}
+
+func TestDiscoverMonitors(t *testing.T) {
+	const qs = `{"quorum_names":["a"],"monmap":{"mons":[{"name":"a","public_addr":"10.7.3.1:6789/0"},{"name":"%s","public_addr":"10.7.3.2:6789/0"}]}}`
+
+	mons, err := discoverMonitors(staticRunner(strings.Replace(qs, "%s", "b", 1)), []string{"mon1"})
+	want := []monitor{{"a", "10.7.3.1:6789/0", true}, {"b", "10.7.3.2:6789/0", false}}
+	if err != nil || !reflect.DeepEqual(mons, want) {
+		t.Errorf("discoverMonitors = %v, %v, want %v", mons, err, want)
+	}
+
+	if mons, err := discoverMonitors(staticRunner(strings.Replace(qs, "%s", "-oProxyCommand=x", 1)), []string{"mon1"}); err == nil {
+		t.Errorf("discoverMonitors with an option as name = %v, want an error", mons)
+	}
+}
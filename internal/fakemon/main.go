@@ -0,0 +1,202 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Fakemon is a SSH server pretending to be a Ceph monitor. It answers the
+// remote commands executed by ceph-get-clients with canned output, so the
+// whole pipeline (SSH, parsing, merging, output) can be exercised without a
+// Ceph cluster.
+//
+// Usage:
+//
+//	go run ./internal/fakemon [-listen 127.0.0.1:2222 -sessions sessions.json]
+//
+// Any user and public key is accepted. Point ceph-get-clients at it using the
+// -port flag and an arbitrary user:
+//
+//	ceph-get-clients -user test -port 2222 127.0.0.1
+//
+// Port forwarding (direct-tcpip) is supported as well, so a second instance
+// can be reached through the first one with -gateway.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func main() {
+	var (
+		listen   = flag.String("listen", "127.0.0.1:2222", "Address to listen on.")
+		sessions = flag.String("sessions", "internal/fakemon/testdata/sessions.json", "File with the output of 'ceph daemon mon.<id> sessions'.")
+		fsid     = flag.String("fsid", "7b1c3b5e-0e4b-4a8e-9d3c-2f6a5d1e0c42", "Cluster FSID returned by 'ceph fsid'.")
+		stdin    = flag.Bool("stdin", false, "Exit once the standard input is closed, so that a test can stop it through 'go run'.")
+	)
+	flag.Parse()
+
+	if *stdin {
+		go func() {
+			io.Copy(ioutil.Discard, os.Stdin)
+			os.Exit(0)
+		}()
+	}
+
+	out, err := ioutil.ReadFile(*sessions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s\n", l.Addr())
+
+	commands := map[string]string{
+		"sessions":         string(out),
+		"ceph fsid":        *fsid + "\n",
+		"ls /var/run/ceph": "ceph-mon.fakemon.asok\nceph-osd.0.asok\n",
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go serve(conn, config, commands)
+	}
+}
+
+// forward connects a direct-tcpip channel to the requested address.
+func forward(nc ssh.NewChannel) {
+	var p struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &p); err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	addr := net.JoinHostPort(p.Host, strconv.Itoa(int(p.Port)))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	log.Printf("forwarding to %s\n", addr)
+
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+	}()
+	io.Copy(conn, ch)
+	conn.Close()
+	ch.Close()
+}
+
+// serve handles a single SSH connection, answering exec requests with the
+// output of the first command whose key is contained in the command line.
+func serve(conn net.Conn, config *ssh.ServerConfig, commands map[string]string) {
+	defer conn.Close()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("handshake failed: %v\n", err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() == "direct-tcpip" {
+			go forward(nc)
+			continue
+		}
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "only session and direct-tcpip channels are supported")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			log.Printf("unable to accept channel: %v\n", err)
+			continue
+		}
+
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+
+				// The payload of an exec request is the length prefixed
+				// command line.
+				var cmd string
+				if len(req.Payload) > 4 {
+					cmd = string(req.Payload[4:])
+				}
+				req.Reply(true, nil)
+				log.Printf("exec %q\n", cmd)
+
+				status := uint32(1)
+				if out, ok := lookup(commands, cmd); ok {
+					fmt.Fprint(ch, out)
+					status = 0
+				} else {
+					fmt.Fprintf(ch.Stderr(), "fakemon: unknown command %q\n", cmd)
+				}
+
+				b := make([]byte, 4)
+				binary.BigEndian.PutUint32(b, status)
+				ch.SendRequest("exit-status", false, b)
+				return
+			}
+		}()
+	}
+}
+
+func lookup(commands map[string]string, cmd string) (string, bool) {
+	for k, out := range commands {
+		if strings.Contains(cmd, k) {
+			return out, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// closestFirst returns the hosts ordered by the round trip time of a TCP
+// connect to their SSH port, measured in parallel and bounded by timeout.
+// Hosts which can not be reached keep their order at the end, so that they
+// are still tried if all others fail.
+func closestFirst(hosts []string, port int, timeout time.Duration) []string {
+	rtt := make([]time.Duration, len(hosts))
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h string) {
+			defer wg.Done()
+			start := time.Now()
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(h, strconv.Itoa(port)), timeout)
+			if err != nil {
+				log.Printf("unable to measure the round trip time to %s: %v\n", h, err)
+				rtt[i] = -1
+				return
+			}
+			rtt[i] = time.Since(start)
+			conn.Close()
+		}(i, h)
+	}
+	wg.Wait()
+
+	idx := make([]int, len(hosts))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		ra, rb := rtt[idx[a]], rtt[idx[b]]
+		if ra < 0 || rb < 0 {
+			return rb < 0 && ra >= 0
+		}
+		return ra < rb
+	})
+
+	sorted := make([]string, len(hosts))
+	desc := make([]string, len(hosts))
+	for i, j := range idx {
+		sorted[i] = hosts[j]
+		desc[i] = hosts[j]
+		if rtt[j] >= 0 {
+			desc[i] += " (" + rtt[j].Round(time.Microsecond).String() + ")"
+		}
+	}
+	if len(hosts) > 1 {
+		log.Printf("monitors by round trip time: %s\n", strings.Join(desc, ", "))
+	}
+	return sorted
+}
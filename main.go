@@ -10,6 +10,8 @@
 // Usage:
 //
 //  ceph-get-clients -user cephssh [-port 22 -feature 0x200000] mon1 mon2 mon3
+//  ceph-get-clients -use-system-ssh [-user cephssh] mon1 mon2 mon3
+//  ceph-get-clients gen man|bash
 //
 // Ceph-get-clients will connect to the given Ceph monitor servers using SSH and
 // retrieve all currently connected clients using `ceph daemon mon.<hostname>
@@ -25,28 +27,36 @@
 //  - SSH_AUTH_SOCK should be set and point to the running ssh agent socket
 //  - SSH user should have sudo rights without password
 //
+// With -use-system-ssh the local ssh binary is used instead, so its
+// configuration (~/.ssh/config, GSSAPI/Kerberos, smartcards, ...) applies and
+// neither -user nor a running ssh agent are required. GSSAPI was deliberately
+// not added to the embedded SSH client, as it would require a Kerberos
+// implementation as dependency, use -use-system-ssh for Kerberos-only monitors.
+//
 // Example:
 //
 //  ceph-get-client -user cephadm -feature 0x200000 mon1 mon2 mon3
-//  IP,feature,release,fqdn,0x200000
-//  10.7.3.67,0x3ffddff8eea4fffb,luminous,clienta.fqdn.tld.,true
-//  10.7.3.65,0x3ffddff8eea4fffb,luminous,webserver.fqdn.tld.,true
-//  10.7.3.64,0x7010fb86aa42ada,jewel,,true
-//  10.7.3.70,0x1ffddff8eea4fffb,luminous,usera.fqdn.tld.,true
+//  IP,feature,release,fqdn,domain,family,entity,global_id,global_id_status,state,kind,implementation,mixed_release,msgr,0x200000
+//  10.7.3.67,0x3ffddff8eea4fffb,luminous,clienta.fqdn.tld.,fqdn.tld,ipv4,client.84123,84123,reclaim_ok,open,librados,userspace,false,v1,true
+//  10.7.3.65,0x3ffddff8eea4fffb,luminous,webserver.fqdn.tld.,fqdn.tld,ipv4,client.84127,84127,reclaim_ok,open,librados,userspace,false,v1,true
+//  10.7.3.64,0x7010fb86aa42ada,jewel,,,ipv4,client.73002,73002,reclaim_ok,open,kernel,kernel,false,v1,true
+//  10.7.3.70,0x1ffddff8eea4fffb,luminous,usera.fqdn.tld.,fqdn.tld,ipv4,client.85410,85410,reclaim_ok,open,librados,userspace,false,v1,true
 //
 package main
 
 import (
-	"encoding/csv"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/netip"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -54,162 +64,1266 @@ import (
 
 func main() {
 	var (
-		user    = flag.String("user", "", "SSH username.")
-		port    = flag.Int("port", 22, "SSH server port.")
-		feature = flag.String("feature", "", "Check if the clients have the features. (e.g. '0x200000' will check if the client supports the upmap feature)")
+		user       = flag.String("user", "", "SSH username.")
+		port       = flag.Int("port", 22, "SSH server port.")
+		sshTimeout = flag.Duration("ssh-timeout", 10*time.Second, "Timeout for establishing an SSH connection (0 means no timeout).")
+		gateway    = flag.String("gateway", "", "Only connect to this host directly and reach all monitors by forwarding their SSH port through it.")
+		closest    = flag.Bool("prefer-closest", false, "Measure the round trip time to the SSH port of the monitors and run the commands only needed once (fsid, watchers, -extra-cmd, -interactive) on the closest one first.")
+		keepalive  = flag.Duration("ssh-keepalive", 15*time.Second, "Interval of SSH keepalive requests, a connection is aborted after 3 unanswered ones (0 disables keepalives).")
+		feature    = flag.String("feature", "", "Check if the clients have the features. (e.g. '0x200000' or 'upmap' will check if the client supports the upmap feature, 'upmap,crush_v4' checks both)")
+		featMatch  = flag.String("feature-match", "all", "Whether clients need all or any of the bits of the -feature mask.")
+		featureDB  = flag.String("feature-db", "", "JSON file with additional or updated feature definitions ([{\"name\": ..., \"mask\": ..., \"description\": ...}]).")
+		systemSSH  = flag.Bool("use-system-ssh", false, "Use the local OpenSSH client instead of the embedded SSH implementation.")
+		readOnly   = flag.Bool("read-only", true, "Refuse to run remote commands which are not known to be read-only.")
+		detectSock = flag.Bool("detect-asok", true, "Look up the monitor admin socket in "+socketDir+" instead of assuming the monitor is named mon.<host>. Disabled with -remote-wrapper unless given explicitly.")
+		interact   = flag.Bool("interactive", false, "List the monitors of the monmap, as reported by the given monitors, and ask which ones to query.")
+		asokGlob   = flag.String("asok-glob", "", "Look up the monitor admin socket using this pattern instead of in "+socketDir+", e.g. '/var/run/ceph/*/ceph-mon.*.asok' for cephadm deployments.")
+		maxOutput  = flag.Int64("max-output", 64<<20, "Maximum size in bytes of the output of a remote command, larger output is treated as failure (0 disables the limit).")
+		debugDump  = flag.String("debug-dump", "", "Save the raw output of every remote command to this directory, e.g. to report parsing failures.")
+		wrapper    = flag.String("remote-wrapper", "", "Run 'sudo <wrapper> <mon id>' instead of 'sudo ceph daemon mon.<mon id> sessions' on the monitors.")
+		preflight  = flag.Bool("preflight", false, "Check that sudo works without a password on every host before collecting and skip the hosts where it does not.")
+		source     = flag.String("source", sourceSSH, "How to retrieve the sessions: ssh (ceph daemon on the monitor hosts), local (ceph daemon on this host), cephadm (ceph daemon in the cephadm container of the monitor) or rook (ceph tell in the rook toolbox, hosts are monitor ids).")
+		rookNS     = flag.String("rook-namespace", "rook-ceph", "Kubernetes namespace of the rook toolbox used with -source rook.")
+
+		vaultAddr  = flag.String("vault-addr", os.Getenv("VAULT_ADDR"), "Address of the HashiCorp Vault server used to sign a short lived SSH certificate. The token is read from VAULT_TOKEN.")
+		vaultRole  = flag.String("vault-role", "", "Role of the Vault SSH secrets engine used for signing.")
+		vaultMount = flag.String("vault-mount", "ssh", "Mount path of the Vault SSH secrets engine.")
+
+		noDNS          = flag.Bool("no-dns", false, "Skip DNS lookups of clients and monitors (duplicate monitors are then only detected by name).")
+		dnsTimeout     = flag.Duration("dns-timeout", 2*time.Second, "Timeout of a single reverse DNS lookup.")
+		hostsOverride  = flag.String("hosts-override", "", "File in /etc/hosts format with names taking precedence over reverse DNS.")
+		dnsDomain      = flag.String("dns-append-domain", "", "Append this domain to short names returned by reverse DNS or -hosts-override and normalize all names to lower case with a trailing dot.")
+		rrPolicy       = flag.String("rr-policy", rrAll, "How to query monitor names resolving to multiple addresses (round-robin names): all addresses, the first one or the name as is.")
+		dnsParallel    = flag.Int("dns-parallel", 1, "Maximum number of reverse DNS lookups in flight.")
+		dnsBudget      = flag.Duration("dns-budget", 0, "Limit the total time spent on reverse DNS lookups, remaining lookups are skipped (0 means no limit).")
+		dnsOverBudget  = flag.String("dns-over-budget", "", "Name used as fqdn of clients whose lookup was skipped because of -dns-budget, e.g. 'unresolved'.")
+		resolveCmd     = flag.String("resolve-cmd", "", "Run this local command for every client, with {ip} replaced by its address, and add its output as 'resolved' column (e.g. './lookup.sh {ip}').")
+		resolvePar     = flag.Int("resolve-parallel", 4, "Maximum number of -resolve-cmd commands running at once.")
+		resolveTimeout = flag.Duration("resolve-timeout", 5*time.Second, "Timeout of a single -resolve-cmd command (0 means no timeout).")
+		ptrCache       = flag.String("ptr-cache", "", "File with pre-fetched reverse DNS names in /etc/hosts format or as reverse zone dump (e.g. from 'dig axfr'), used before querying DNS.")
+		dnsMaxTimeouts = flag.Int("dns-max-timeouts", 5, "Stop reverse DNS lookups after this many consecutive timeouts (0 means never).")
+		state          = flag.String("state", "open", "Only include sessions in the given state: open, closed or all. Sessions with unknown state are always included.")
+		expectMsgr     = flag.String("expect-msgr", "", "Warn about clients not using the given messenger protocol (v1 or v2).")
+		dedupKey       = flag.String("dedup-key", dedupIP, "Merge sessions with the same ip, entity or ip+entity into one client.")
+		confirm        = flag.Duration("confirm", 0, "Poll the monitors a second time after this delay and only report clients seen in both polls (e.g. 30s).")
+		insecureGID    = flag.Bool("insecure-global-id", false, "Only include clients using insecure global_id reclaim (CVE-2021-20288).")
+		includeLocal   = flag.Bool("include-local", false, "Include sessions from loopback and link-local addresses, which are dropped by default.")
+		includeSelf    = flag.Bool("include-self", false, "Include sessions from the addresses of the monitor hosts, which are dropped by default. Monitors given by name are only resolved without -no-dns.")
+		kinds          = flag.String("kind", "", "Comma separated list of client kinds to include (kernel, librados, rgw, mgr, daemon, unknown).")
+		top            = flag.Int("top", 0, "Only output the given number of clients with the oldest release, sorted by release.")
+		filterExpr     = flag.String("filter", "", "Only include clients matching the expression, e.g. 'release == \"jewel\" && fqdn endswith \".lab.tld.\"'.")
+		mergeDualStack = flag.Bool("merge-dual-stack", false, "Merge IPv4 and IPv6 clients resolving to the same fqdn into one client.")
+
+		format        = flag.String("output", "csv", "Output format: csv, table, grafana (JSON datasource table) or ansible-inventory (YAML inventory grouped by release and domain).")
+		baselineFile  = flag.String("baseline", "", "Snapshot file (see -save-snapshot) to report the upgrade progress against in the -summary.")
+		targetRelease = flag.String("target-release", "", "Release clients should be upgraded to, used with -baseline. Defaults to the newest release of all clients.")
+		metricsFile   = flag.String("metrics", "", "Write the number of clients per release, per monitor collection results and the ratio supporting -feature in the Prometheus text format to the given file (e.g. for the node_exporter textfile collector).")
+		saveSnapshot  = flag.String("save-snapshot", "", "Save a snapshot of the clients to the given file, e.g. for later use with -baseline.")
+		summary       = flag.Bool("summary", false, "Write a summary with the number of clients per release (and supporting -feature) instead of the clients.")
+		lang          = flag.String("lang", "en", "Language of the -summary, -report and -output table texts: en or de.")
+		reportFmt     = flag.String("report", "", "Write a short report instead of the clients: text (one sentence about clients, outdated releases, missing PTR records and unreachable monitors, listing the outdated clients), e.g. for handover notes.")
+		output        = flag.String("o", "", "Write the output to the given file instead of Stdout.")
+		appendOutput  = flag.Bool("append", false, "Append to the file given by -o instead of overwriting it.")
+		noHeader      = flag.Bool("no-header", false, "Do not write the header row.")
+		bom           = flag.Bool("bom", false, "Start the output with a UTF-8 byte order mark, so Excel detects the encoding.")
+		crlf          = flag.Bool("crlf", false, "Terminate CSV lines with \\r\\n instead of \\n.")
+
+		splitBy      = flag.String("split-by", "", "Write one file per release, kind, domain or role to the directory given by -o (e.g. jewel.csv, luminous.csv).")
+		clustersFmt  = flag.String("clusters-format", "clusters", "Format of the -clusters file: clusters or inventory (dashboard inventory listing the mon_host of each cluster).")
+		clustersFile = flag.String("clusters", "", "YAML file with the monitors of multiple clusters. Writes one file per cluster in the output format and a summary.csv to the directory given by -o.")
+		parallel     = flag.Int("parallel", 4, "Number of clusters collected in parallel with -clusters.")
+
+		onlyOnChange  = flag.String("only-on-change", "", "Compare the clients against the snapshot in the given file and only write the output and -syslog-stream if they changed, -metrics, -run-report and the exit status are not affected. The snapshot is updated afterwards.")
+		signKey       = flag.String("sign-key", "", "Unencrypted SSH private key used to sign the file given by -o, the signature is written to <file>.sig in the format of 'ssh-keygen -Y sign' (namespace file).")
+		secureMode    = flag.Bool("secure-mode-estimate", false, "Add an estimate of the clients unable to connect with ms_client_mode secure, which requires msgr2, to the -summary.")
+		expectMax     = flag.Int("expect-max", -1, "Exit with status 4 if more than this many clients are reported, e.g. together with -filter to gate migrations (-1 disables the check).")
+		expectZero    = flag.Bool("expect-zero", false, "Like -expect-max 0.")
+		syslogAddr    = flag.String("syslog-stream", "", "Send one RFC 5424 syslog message with structured data per client to host:port (UDP) or tcp://host:port.")
+		runReportFile = flag.String("run-report", "", "Write metadata about the run as JSON to the given file.")
+
+		fsid = flag.Bool("fsid", false, "Add a column with the cluster FSID (retrieved using 'ceph fsid').")
+
+		rolesFile     = flag.String("roles", "", "YAML file listing the infrastructure clients. Adds a 'role' column (infrastructure or tenant) and per role counts to the -summary.")
+		notesFile     = flag.String("annotations", "", "YAML file with notes about clients by IP or fqdn. Adds a 'note' column.")
+		ownersFile    = flag.String("domain-owners", "", "YAML file mapping DNS domains to owners. Adds an 'owner' column.")
+		allowlistFile = flag.String("allowlist", "", "YAML file with the approved clients. Adds an 'allowed' column and exits with status 1 if unapproved clients are connected or approved ones are missing.")
+
+		fqdnFormat   = flag.String("fqdn-format", "space", "Format of the fqdn column of clients with multiple names: space (separated) or json (array).")
+		timestampCol = flag.Bool("timestamp-column", false, "Add a 'timestamp' column with the RFC3339 collection time of each client.")
+		timezone     = flag.String("timezone", "Local", "Time zone of the -timestamp-column, e.g. UTC or Europe/Rome.")
+
+		redactCols = flag.String("redact", "", "Comma separated list of columns to redact in the output (e.g. 'feature,fqdn').")
+		redactHash = flag.Bool("redact-hash", false, "Replace redacted values by a short keyed hash (HMAC-SHA256) instead of blanking them.")
+		redactKeyF = flag.String("redact-key", "", "File with the key of -redact-hash, so hashes are comparable between runs. Defaults to a random key per run.")
+	)
+	var (
+		tags        tagList
+		also        outputList
+		extraCmds   stringList
+		watchers    stringList
+		allWatchers stringList
 	)
+	flag.Var(&also, "also", "Additionally write the clients in the `format=file`, e.g. grafana=clients.json. Formats are those of -output, summary and report. Can be repeated.")
+	flag.Var(&tags, "tag", "Add a constant `key=value` column to the output. Can be repeated.")
+	flag.Var(&extraCmds, "extra-cmd", "Run the `command` on the first reachable monitor and attach its output to the -run-report. Can be repeated.")
+	flag.Var(&watchers, "watchers", "Add the clients watching the RBD `pool/image` and a 'watches' column. Can be repeated.")
+	flag.Var(&allWatchers, "all-watchers", "Like -watchers for all images of the `pool`. Can be repeated.")
+
+	// 'gen man|bash' writes the man page or bash completion generated from
+	// the flags defined above.
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		if len(os.Args) != 3 {
+			log.Fatal("usage: ceph-get-clients gen man|bash")
+		}
+		if err := writeGenerated(os.Stdout, os.Args[2]); err != nil {
+			log.Fatalf("error gen: %v", err)
+		}
+		return
+	}
 	flag.Parse()
 
-	if *user == "" {
+	if *user == "" && !*systemSSH {
 		log.Fatal("error missing -user")
 	}
 
-	if flag.NArg() < 1 {
+	if flag.NArg() < 1 && *clustersFile == "" {
 		log.Fatal("missing host")
 	}
+	for _, h := range flag.Args() {
+		if err := checkHost(h); err != nil {
+			log.Fatalf("error %v", err)
+		}
+	}
 
-	sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
-	if err != nil {
-		log.Fatalf("could not find ssh agent: %v", err)
+	if *clustersFmt != "clusters" && *clustersFmt != "inventory" {
+		log.Fatalf("error -clusters-format must be clusters or inventory, got %q", *clustersFmt)
+	}
+	if *interact && (*clustersFile != "" || !isTerminal(os.Stdin)) {
+		log.Fatal("error -interactive needs a terminal and cannot be used with -clusters")
 	}
 
-	agentClient := agent.NewClient(sshAgent)
-	config := &ssh.ClientConfig{
-		User: *user,
-		Auth: []ssh.AuthMethod{
-			// Use a callback rather than PublicKeys so we only consult the
-			// agent once the remote server wants it.
-			ssh.PublicKeysCallback(agentClient.Signers),
-		},
-		// TODO: quick & dirty
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	if *clustersFile != "" && (*output == "" || *appendOutput) {
+		log.Fatal("error -clusters requires -o <dir> and cannot be used with -append")
 	}
 
-	var clients []*Client
-	for _, h := range flag.Args() {
-		client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", h, *port), config)
+	if *featureDB != "" {
+		if err := loadFeatureDB(*featureDB); err != nil {
+			log.Fatalf("error -feature-db: %v", err)
+		}
+	}
+
+	if *featMatch != "all" && *featMatch != "any" {
+		log.Fatalf("error -feature-match must be all or any, got %q", *featMatch)
+	}
+	matchAll := *featMatch == "all"
+
+	featureMask := ""
+	if *feature != "" {
+		var err error
+		featureMask, err = resolveFeature(*feature)
 		if err != nil {
-			log.Printf("unable to connect: %v\n", err)
-			continue
+			log.Fatalf("error -feature: %v", err)
 		}
+	}
 
-		sess, err := client.NewSession()
+	if *splitBy != "" && (*output == "" || *appendOutput || *clustersFile != "" || *allowlistFile != "") {
+		log.Fatal("error -split-by requires -o <dir> and cannot be used with -append, -clusters or -allowlist")
+	}
+
+	if *clustersFile != "" && *runReportFile != "" {
+		log.Fatal("error -run-report cannot be used with -clusters")
+	}
+
+	if *clustersFile != "" && (*onlyOnChange != "" || *saveSnapshot != "" || *metricsFile != "") {
+		log.Fatal("error -only-on-change, -save-snapshot and -metrics cannot be used with -clusters")
+	}
+
+	if *fqdnFormat != "space" && *fqdnFormat != "json" {
+		log.Fatalf("error -fqdn-format must be space or json, got %q", *fqdnFormat)
+	}
+	if *dedupKey != dedupIP && *dedupKey != dedupEntity && *dedupKey != dedupIPEntity {
+		log.Fatalf("error -dedup-key must be %s, %s or %s, got %q", dedupIP, dedupEntity, dedupIPEntity, *dedupKey)
+	}
+	if *expectMsgr != "" && *expectMsgr != "v1" && *expectMsgr != "v2" {
+		log.Fatalf("error -expect-msgr must be v1 or v2, got %q", *expectMsgr)
+	}
+	if *clustersFile != "" && (len(watchers) > 0 || len(allWatchers) > 0) {
+		log.Fatal("error -watchers and -all-watchers cannot be used with -clusters")
+	}
+	if len(extraCmds) > 0 && *runReportFile == "" {
+		log.Fatal("error -extra-cmd requires -run-report")
+	}
+
+	switch *format {
+	case "csv", "table":
+	case "grafana", "ansible-inventory":
+		if *appendOutput || *bom {
+			log.Fatalf("error -output %s cannot be used with -append or -bom", *format)
+		}
+	default:
+		log.Fatalf("error unknown -output format %q", *format)
+	}
+	if *redactCols != "" {
+		alsoInventory := false
+		for _, o := range also {
+			alsoInventory = alsoInventory || o.format == "ansible-inventory"
+		}
+		if *format == "ansible-inventory" || alsoInventory || *syslogAddr != "" || *saveSnapshot != "" || *onlyOnChange != "" {
+			log.Fatal("error -redact cannot be used with -output ansible-inventory, -syslog-stream, -save-snapshot or -only-on-change, which need the clear values")
+		}
+		for _, col := range strings.Split(*redactCols, ",") {
+			if *splitBy != "" && strings.TrimSpace(col) == *splitBy {
+				log.Fatalf("error -redact %s cannot be used with -split-by %s, which names the files by the clear values", *splitBy, *splitBy)
+			}
+		}
+	}
+	if *redactKeyF != "" && !*redactHash {
+		log.Fatal("error -redact-key requires -redact-hash")
+	}
+	if *reportFmt != "" && *reportFmt != "text" {
+		log.Fatalf("error -report must be text, got %q", *reportFmt)
+	}
+	if *reportFmt != "" && *summary {
+		log.Fatal("error -report cannot be used with -summary")
+	}
+	for _, o := range also {
+		switch o.format {
+		case "csv", "table", "grafana", "ansible-inventory", "summary", "report":
+		default:
+			log.Fatalf("error -also: unknown format %q", o.format)
+		}
+	}
+	if len(also) > 0 && (*clustersFile != "" || *splitBy != "") {
+		log.Fatal("error -also cannot be used with -clusters or -split-by")
+	}
+
+	if *state != string(stateOpen) && *state != string(stateClosed) && *state != "all" {
+		log.Fatalf("error unknown -state %q", *state)
+	}
+
+	if *parallel < 1 {
+		log.Fatal("error -parallel must be at least 1")
+	}
+	if *rrPolicy != rrAll && *rrPolicy != rrFirst && *rrPolicy != rrName {
+		log.Fatalf("error -rr-policy must be all, first or name, got %q", *rrPolicy)
+	}
+	if *resolvePar < 1 {
+		log.Fatal("error -resolve-parallel must be at least 1")
+	}
+	if *dnsParallel < 1 {
+		log.Fatal("error -dns-parallel must be at least 1")
+	}
+
+	if *vaultRole != "" && (*systemSSH || *vaultAddr == "") {
+		log.Fatal("error -vault-role requires -vault-addr and cannot be used with -use-system-ssh")
+	}
+
+	if *appendOutput && *output == "" {
+		log.Fatal("error -append requires -o")
+	}
+
+	if *expectZero {
+		*expectMax = 0
+	}
+
+	var stream *syslogStream
+	if *syslogAddr != "" {
+		var err error
+		stream, err = dialSyslog(*syslogAddr)
 		if err != nil {
-			log.Printf("unable to create session: %v\n", err)
-			continue
+			log.Fatalf("error -syslog-stream: %v", err)
 		}
+		defer stream.Close()
+	}
 
-		out, err := sess.Output(fmt.Sprintf("sudo ceph daemon mon.%s sessions", h))
+	var signer ssh.Signer
+	if *signKey != "" {
+		if *output == "" || *clustersFile != "" || *splitBy != "" {
+			log.Fatal("error -sign-key requires -o and cannot be used with -clusters or -split-by")
+		}
+		var err error
+		signer, err = readSigner(*signKey)
 		if err != nil {
-			log.Printf("unable to execute 'ceph daemon mon.%s sessions: %v", h, err)
-			continue
+			log.Fatalf("error -sign-key: %v", err)
 		}
+	}
 
-		var c []*Client
-		if err := json.Unmarshal([]byte(out), &c); err != nil {
-			log.Printf("unable to unmarshal sessions: %v\n", err)
-			continue
+	switch *source {
+	case sourceSSH, sourceLocal, sourceCephadm, sourceRook:
+	default:
+		log.Fatalf("error unknown -source %q", *source)
+	}
+	if *closest && (*gateway != "" || *source == sourceLocal || *source == sourceRook) {
+		log.Fatal("error -prefer-closest cannot be used with -gateway or -source local or rook")
+	}
+	if *preflight && *source == sourceRook {
+		log.Fatal("error -preflight cannot be used with -source rook")
+	}
+	if *wrapper != "" && *source != sourceSSH && *source != sourceLocal {
+		log.Fatalf("error -remote-wrapper cannot be used with -source %s", *source)
+	}
+	if *asokGlob != "" && !validGlob(*asokGlob) {
+		log.Fatalf("error -asok-glob may only contain path names and the wildcards *, ? and [...], got %q", *asokGlob)
+	}
+
+	var r runner
+	switch {
+	case *source == sourceLocal:
+		r = localRunner{maxOutput: *maxOutput}
+	case *source == sourceRook:
+		r = &rookRunner{namespace: *rookNS, toolbox: "deploy/rook-ceph-tools", maxOutput: *maxOutput}
+	case *systemSSH:
+		sr := &systemSSHRunner{user: *user, timeout: *sshTimeout, keepalive: *keepalive, gateway: *gateway, maxOutput: *maxOutput}
+		if isFlagSet("port") {
+			sr.port = *port
+		}
+		r = sr
+	default:
+		config := &ssh.ClientConfig{
+			User: *user,
+			// TODO: quick & dirty
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		}
+
+		if *vaultRole != "" {
+			signer, err := vaultSigner(*vaultAddr, *vaultMount, *vaultRole, os.Getenv("VAULT_TOKEN"), *user)
+			if err != nil {
+				log.Fatalf("unable to get ssh certificate from vault: %v", err)
+			}
+			config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+		} else {
+			sshAgent, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+			if err != nil {
+				log.Fatalf("could not find ssh agent: %v", err)
+			}
+
+			agentClient := agent.NewClient(sshAgent)
+			config.Auth = []ssh.AuthMethod{
+				// Use a callback rather than PublicKeys so we only consult the
+				// agent once the remote server wants it.
+				ssh.PublicKeysCallback(agentClient.Signers),
+			}
+		}
+		r = &sshRunner{config: config, port: *port, timeout: *sshTimeout, keepalive: *keepalive, gateway: *gateway, maxOutput: *maxOutput}
+	}
+
+	if *debugDump != "" {
+		if err := os.MkdirAll(*debugDump, 0755); err != nil {
+			log.Fatalf("error -debug-dump: %v", err)
 		}
+		r = &dumpRunner{r: r, dir: *debugDump}
+	}
 
-		for _, add := range c {
-			clients = unique(clients, add)
+	if *readOnly {
+		ro := &readOnlyRunner{r: r}
+		if *wrapper != "" {
+			// The wrapper is passed the monitor id, -preflight
+			// runs sudo -l <wrapper>.
+			w := strings.Fields(*wrapper)
+			ro.extra = append(ro.extra, w, append(w[:len(w):len(w)], "*"))
 		}
+		if *asokGlob != "" {
+			ro.extra = append(ro.extra, strings.Fields(globCommand(*asokGlob)))
+		}
+		r = ro
+	}
+	if *source == sourceCephadm {
+		r = &cephadmRunner{r: r}
+	}
 
-		sess.Close()
-		client.Close()
+	var src sessionSource
+	switch *source {
+	case sourceCephadm:
+		src = &cephadmSource{r: r}
+	case sourceRook:
+		src = &tellSource{r: r}
+	default:
+		// Sudoers of hosts using a wrapper usually only allow the
+		// wrapper, only look up the socket there if asked to.
+		detect := *detectSock
+		if *wrapper != "" && !isFlagSet("detect-asok") {
+			detect = false
+		}
+		src = &daemonSource{r: r, wrapper: *wrapper, detectSocket: detect || *asokGlob != "", asokGlob: *asokGlob}
 	}
 
-	w := csv.NewWriter(os.Stdout)
+	col := &collector{
+		r:            r,
+		src:          src,
+		fsid:         *fsid,
+		state:        *state,
+		images:       watchers,
+		pools:        allWatchers,
+		confirm:      *confirm,
+		resolveHosts: !*noDNS,
+		rrPolicy:     *rrPolicy,
+		preflight:    *preflight,
+		wrapper:      *wrapper,
+		dedup:        *dedupKey,
+		includeSelf:  *includeSelf,
+	}
+	if *closest {
+		col.order = func(hosts []string) []string {
+			return closestFirst(hosts, *port, *sshTimeout)
+		}
+	}
 
-	header := []string{"IP", "feature", "release", "fqdn"}
-	if *feature != "" {
-		header = append(header, *feature)
+	var list *allowlist
+	if *allowlistFile != "" {
+		var err error
+		list, err = readAllowlist(*allowlistFile)
+		if err != nil {
+			log.Fatalf("error -allowlist: %v", err)
+		}
 	}
-	w.Write(header)
 
-	for _, s := range clients {
-		line := []string{s.IP, s.Feature, s.Release}
+	var clientRoles *roles
+	if *rolesFile != "" {
+		var err error
+		clientRoles, err = readRoles(*rolesFile)
+		if err != nil {
+			log.Fatalf("error -roles: %v", err)
+		}
+	}
 
-		names, _ := net.LookupAddr(s.IP)
-		line = append(line, strings.Join(names, " "))
+	var owners domainOwners
+	if *ownersFile != "" {
+		var err error
+		owners, err = readDomainOwners(*ownersFile)
+		if err != nil {
+			log.Fatalf("error -domain-owners: %v", err)
+		}
+	}
 
+	var notes *annotations
+	if *notesFile != "" {
+		var err error
+		notes, err = readAnnotations(*notesFile)
+		if err != nil {
+			log.Fatalf("error -annotations: %v", err)
+		}
+	}
+
+	var overrides map[string]string
+	if *hostsOverride != "" {
+		var err error
+		overrides, err = readHostsFile(*hostsOverride)
+		if err != nil {
+			log.Fatalf("error -hosts-override: %v", err)
+		}
+	}
+	if *ptrCache != "" {
+		cache, err := readPTRCache(*ptrCache)
+		if err != nil {
+			log.Fatalf("error -ptr-cache: %v", err)
+		}
+		// Overrides take precedence over the cache.
+		for ip, name := range overrides {
+			cache[ip] = name
+		}
+		overrides = cache
+	}
+
+	var match filter
+	if *filterExpr != "" {
+		var err error
+		match, err = parseFilter(*filterExpr, defaultColumns())
+		if err != nil {
+			log.Fatalf("error -filter: %v", err)
+		}
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		log.Fatalf("error -timezone: %v", err)
+	}
+
+	var target Release
+	if *targetRelease != "" {
+		target = parseRelease(*targetRelease)
+		if !target.Known() {
+			log.Fatalf("error -target-release: unknown release %q", *targetRelease)
+		}
+	}
+
+	var hashKey []byte
+	if *redactHash {
+		var err error
+		hashKey, err = redactKey(*redactKeyF)
+		if err != nil {
+			log.Fatalf("error -redact-key: %v", err)
+		}
+	}
+
+	msg, err := lookupMessages(*lang)
+	if err != nil {
+		log.Fatalf("error -lang: %v", err)
+	}
+
+	var base *snapshot
+	if *baselineFile != "" {
+		var err error
+		base, err = readSnapshot(*baselineFile)
+		if err != nil {
+			log.Fatalf("error -baseline: %v", err)
+		}
+	}
+
+	// prepare filters, sorts and enriches the collected clients.
+	prepare := func(clients []*Client) []*Client {
+		if !*includeLocal {
+			var filtered []*Client
+			for _, c := range clients {
+				if !c.isLocal() {
+					filtered = append(filtered, c)
+				}
+			}
+			if n := len(clients) - len(filtered); n > 0 {
+				log.Printf("skipping %d sessions from loopback or link-local addresses, use -include-local to include them\n", n)
+			}
+			clients = filtered
+		}
+
+		if *kinds != "" {
+			clients = filterKinds(clients, strings.Split(*kinds, ","))
+		}
+
+		if *insecureGID {
+			var filtered []*Client
+			for _, c := range clients {
+				if c.GlobalIDStatus == globalIDReclaimInsecure {
+					filtered = append(filtered, c)
+				}
+			}
+			clients = filtered
+		}
+
+		res := &resolver{
+			timeout:     *dnsTimeout,
+			maxTimeouts: *dnsMaxTimeouts,
+			overrides:   overrides,
+			disabled:    *noDNS,
+			domain:      *dnsDomain,
+			parallel:    *dnsParallel,
+			budget:      *dnsBudget,
+			overBudget:  *dnsOverBudget,
+		}
+		res.resolveAll(clients)
+		if *resolveCmd != "" {
+			cr := &cmdResolver{cmd: *resolveCmd, parallel: *resolvePar, timeout: *resolveTimeout}
+			cr.resolveAll(clients)
+		}
+
+		markMixedReleases(clients)
+		if *expectMsgr != "" {
+			for _, c := range clients {
+				if c.Msgr != *expectMsgr && c.Msgr != unknown {
+					log.Printf("warning: %s (%s) uses msgr %s\n", c.IP(), c.Entity, c.Msgr)
+				}
+			}
+		}
+
+		if match != nil {
+			var filtered []*Client
+			for _, c := range clients {
+				if match(c) {
+					filtered = append(filtered, c)
+				}
+			}
+			clients = filtered
+		}
+
+		if *mergeDualStack {
+			clients = mergeDualStackClients(clients)
+		}
+
+		// Cut last, so that the top clients are the oldest of those
+		// reported.
+		if *top > 0 {
+			sortByRelease(clients)
+			if len(clients) > *top {
+				clients = clients[:*top]
+			}
+		}
+
+		return clients
+	}
+
+	outputFormat := *format
+	if *summary {
+		outputFormat = "summary"
+	}
+	if *reportFmt != "" {
+		outputFormat = "report"
+	}
+
+	// complies reports whether the clients comply with the allowlist, logging
+	// the approved clients which are not connected.
+	complies := func(clients []*Client) bool {
+		if list == nil {
+			return true
+		}
+		compliant := true
+		for _, c := range clients {
+			if !list.allowed(c) {
+				compliant = false
+			}
+		}
+		for _, m := range list.missing(clients) {
+			log.Printf("approved client %s is not connected\n", m)
+			compliant = false
+		}
+		return compliant
+	}
+
+	// write writes the clients in the format to w and reports whether they comply
+	// with the allowlist. If fresh is set, w is at the start of the output and
+	// the header and byte order mark are written.
+	write := func(w io.Writer, format string, clients []*Client, res *collection, fresh bool) (bool, error) {
+		header := fresh && !*noHeader
+		if fresh && *bom && format != "grafana" && format != "ansible-inventory" {
+			if _, err := io.WriteString(w, "\ufeff"); err != nil {
+				return false, err
+			}
+		}
+
+		cols := defaultColumns()
+		if *fqdnFormat == "json" {
+			cols[columnIndex(cols, "fqdn")].value = func(c *Client) string { return jsonList(c.FQDN) }
+		}
 		if *feature != "" {
-			line = append(line, fmt.Sprint(checkForFeatures(s, "200000")))
+			cols = append(cols, column{*feature, func(c *Client) string {
+				return fmt.Sprint(checkForFeatures(c, featureMask, matchAll))
+			}})
 		}
 
-		w.Write(line)
+		if clientRoles != nil {
+			cols = append(cols, column{"role", clientRoles.role})
+		}
+		if owners != nil {
+			cols = append(cols, column{"owner", func(c *Client) string {
+				return owners.owner(domain(c.FQDN))
+			}})
+		}
+		if notes != nil {
+			cols = append(cols, column{"note", notes.note})
+		}
+		if *resolveCmd != "" {
+			cols = append(cols, column{"resolved", func(c *Client) string { return c.Resolved }})
+		}
+
+		compliant := complies(clients)
+		if list != nil {
+			cols = append(cols, column{"allowed", func(c *Client) string {
+				return fmt.Sprint(list.allowed(c))
+			}})
+		}
+
+		if len(watchers) > 0 || len(allWatchers) > 0 {
+			cols = append(cols, column{"watches", func(c *Client) string {
+				return strings.Join(c.Watches, " ")
+			}})
+		}
+		if *timestampCol {
+			cols = append(cols, column{"timestamp", func(c *Client) string {
+				if c.SeenAt.IsZero() {
+					return ""
+				}
+				return c.SeenAt.In(loc).Format(time.RFC3339)
+			}})
+		}
+		if *fsid {
+			cols = append(cols, constColumn("fsid", res.fsid))
+		}
+		for _, t := range tags {
+			cols = append(cols, constColumn(t.key, t.value))
+		}
+
+		if *redactCols != "" {
+			var err error
+			cols, err = redact(cols, strings.Split(*redactCols, ","), hashKey)
+			if err != nil {
+				return false, fmt.Errorf("error -redact: %v", err)
+			}
+		}
+
+		switch format {
+		case "summary":
+			return compliant, writeSummary(w, clients, summaryOptions{
+				labels:     tags.labels(res.fsid),
+				feature:    featureMask,
+				matchAll:   matchAll,
+				baseline:   base,
+				target:     target,
+				roles:      clientRoles,
+				sessions:   res.entityTypes,
+				secureMode: *secureMode,
+				msg:        msg,
+			})
+		case "report":
+			return compliant, writeReport(w, cols, clients, res.monitors, tags.labels(res.fsid), target, !*noDNS, *dnsOverBudget, msg)
+		case "table":
+			return compliant, writeTable(w, cols, clients, header, useColor(w), msg)
+		case "grafana":
+			return compliant, writeGrafana(w, cols, clients)
+		case "ansible-inventory":
+			return compliant, writeAnsibleInventory(w, clients, tags.labels(res.fsid))
+		}
+		return compliant, writeCSV(w, cols, clients, header, *crlf)
 	}
-	w.Flush()
 
-	if err := w.Error(); err != nil {
-		log.Fatal(err)
+	if *clustersFile != "" {
+		read := readClusters
+		if *clustersFmt == "inventory" {
+			read = readInventory
+		}
+		clusters, err := read(*clustersFile)
+		if err != nil {
+			log.Fatalf("error -clusters: %v", err)
+		}
+		if err := os.MkdirAll(*output, 0755); err != nil {
+			log.Fatal(err)
+		}
+
+		var (
+			wg  sync.WaitGroup
+			sem = make(chan struct{}, *parallel)
+
+			mu        sync.Mutex
+			compliant = true
+			failed    = false
+			results   = make(map[string][]*Client)
+			fsids     = make(map[string]string)
+		)
+		for _, cl := range clusters {
+			wg.Add(1)
+			go func(cl cluster) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				res := col.collect(cl.Monitors)
+				clients := prepare(res.clients)
+				if len(clients) == 0 {
+					msg, code := res.diagnoseEmpty()
+					log.Printf("cluster %s: %s\n", cl.Name, msg)
+					if code != 0 {
+						mu.Lock()
+						failed = true
+						mu.Unlock()
+						return
+					}
+				}
+
+				ok, err := writeFile(filepath.Join(*output, fileName(cl.Name)+outputExt(outputFormat)), func(w io.Writer) (bool, error) {
+					return write(w, outputFormat, clients, res, true)
+				})
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					log.Printf("cluster %s: %v\n", cl.Name, err)
+					failed = true
+					return
+				}
+				compliant = compliant && ok
+				results[cl.Name] = clients
+				fsids[cl.Name] = res.fsid
+			}(cl)
+		}
+		wg.Wait()
+
+		name := filepath.Join(*output, "summary.csv")
+		if _, err := writeFile(name, func(w io.Writer) (bool, error) {
+			return true, writeClusterSummary(w, results)
+		}); err != nil {
+			log.Fatal(err)
+		}
+
+		if stream != nil {
+			for name, clients := range results {
+				if err := stream.send(clients, name, tags.labels(fsids[name])); err != nil {
+					log.Fatalf("error -syslog-stream: %v", err)
+				}
+			}
+		}
+
+		if failed || !compliant {
+			os.Exit(1)
+		}
+		unexpected := false
+		for name, clients := range results {
+			if !checkExpectation(name, clients, *expectMax) {
+				unexpected = true
+			}
+		}
+		if unexpected {
+			os.Exit(exitUnexpected)
+		}
+		return
+	}
+
+	hosts := flag.Args()
+	if *interact {
+		near := hosts
+		if col.order != nil {
+			near = col.order(hosts)
+		}
+		mons, err := discoverMonitors(r, near)
+		if err != nil {
+			log.Fatalf("error -interactive: %v", err)
+		}
+		hosts, err = selectMonitors(os.Stdin, os.Stderr, mons)
+		if err != nil {
+			log.Fatalf("error -interactive: %v", err)
+		}
+	}
+
+	rep := &runReport{Started: time.Now()}
+
+	res := col.collect(hosts)
+	rep.Monitors = res.monitors
+	clients := prepare(res.clients)
+	if len(clients) == 0 {
+		msg, code := res.diagnoseEmpty()
+		log.Println(msg)
+		if code != 0 {
+			if *metricsFile != "" {
+				if err := writeMetrics(*metricsFile, clients, res.monitors, featureMask, matchAll, tags.labels(res.fsid)); err != nil {
+					log.Printf("error -metrics: %v\n", err)
+				}
+			}
+			if *runReportFile != "" {
+				rep.Finished = time.Now()
+				if err := rep.write(*runReportFile); err != nil {
+					log.Printf("error -run-report: %v\n", err)
+				}
+			}
+			os.Exit(code)
+		}
+	}
+
+	var (
+		snap      *snapshot
+		unchanged bool
+	)
+	if *onlyOnChange != "" {
+		prev, err := readSnapshot(*onlyOnChange)
+		if err != nil {
+			log.Fatalf("error -only-on-change: %v", err)
+		}
+		snap = newSnapshot(clients)
+		if snap.equal(prev) {
+			// Only the output is skipped, everything else still
+			// depends on the current clients.
+			log.Println("clients did not change since the last run, not writing output")
+			unchanged = true
+		}
+	}
+
+	var compliant bool
+	if unchanged {
+		compliant = complies(clients)
+	} else if *splitBy != "" {
+		groups, err := splitClients(clients, *splitBy, clientRoles)
+		if err != nil {
+			log.Fatalf("error -split-by: %v", err)
+		}
+		if err := os.MkdirAll(*output, 0755); err != nil {
+			log.Fatal(err)
+		}
+
+		ext := outputExt(outputFormat)
+		compliant = true
+		for name, group := range groups {
+			g := group
+			ok, err := writeFile(filepath.Join(*output, name+ext), func(w io.Writer) (bool, error) {
+				return write(w, outputFormat, g, res, true)
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			compliant = compliant && ok
+		}
+	} else {
+		out := os.Stdout
+		fresh := true
+		if *output != "" {
+			mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			if *appendOutput {
+				mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+			f, err := os.OpenFile(*output, mode, 0644)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+
+			// Never repeat the header when appending to a non empty file.
+			if fi, err := f.Stat(); err == nil && *appendOutput && fi.Size() > 0 {
+				fresh = false
+			}
+			out = f
+		}
+
+		var err error
+		compliant, err = write(out, outputFormat, clients, res, fresh)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, o := range also {
+			if _, err := writeFile(o.file, func(w io.Writer) (bool, error) {
+				return write(w, o.format, clients, res, true)
+			}); err != nil {
+				log.Fatalf("error -also: %v", err)
+			}
+		}
+
+		if *output != "" && (signer != nil || *runReportFile != "") {
+			rep.Output, err = digestOutput(*output, signer)
+			if err != nil {
+				log.Fatalf("error -sign-key: %v", err)
+			}
+		}
+	}
+
+	if stream != nil && !unchanged {
+		if err := stream.send(clients, "", tags.labels(res.fsid)); err != nil {
+			log.Fatalf("error -syslog-stream: %v", err)
+		}
+	}
+
+	if snap != nil {
+		if err := snap.write(*onlyOnChange); err != nil {
+			log.Fatalf("error -only-on-change: %v", err)
+		}
+	}
+
+	if *metricsFile != "" {
+		if err := writeMetrics(*metricsFile, clients, res.monitors, featureMask, matchAll, tags.labels(res.fsid)); err != nil {
+			log.Fatalf("error -metrics: %v", err)
+		}
+	}
+
+	if *saveSnapshot != "" {
+		if err := newSnapshot(clients).write(*saveSnapshot); err != nil {
+			log.Fatalf("error -save-snapshot: %v", err)
+		}
+	}
+
+	if *runReportFile != "" {
+		for _, cmd := range extraCmds {
+			rep.ExtraCommands = append(rep.ExtraCommands, runExtra(r, res.near, cmd))
+		}
+		rep.Clients = len(clients)
+		rep.Finished = time.Now()
+		if err := rep.write(*runReportFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if !compliant {
+		os.Exit(1)
+	}
+	if !checkExpectation("", clients, *expectMax) {
+		os.Exit(exitUnexpected)
 	}
 }
 
-func unique(clients []*Client, add *Client) []*Client {
+// exitUnexpected is the exit status if more clients than expected are
+// reported.
+const exitUnexpected = 4
+
+// checkExpectation reports whether there are at most max clients, logging
+// them otherwise. A negative max disables the check.
+func checkExpectation(cluster string, clients []*Client, max int) bool {
+	if max < 0 || len(clients) <= max {
+		return true
+	}
+	prefix := ""
+	if cluster != "" {
+		prefix = "cluster " + cluster + ": "
+	}
+	log.Printf("%s%d clients reported, expected at most %d\n", prefix, len(clients), max)
 	for _, c := range clients {
-		if c.Equal(add) {
-			return clients
+		log.Printf("%sunexpected client %s (%s, %s)\n", prefix, c.IP(), c.Entity, c.Release)
+	}
+	return false
+}
+
+// splitClients groups the clients by the value of the given key, which is one
+// of release, kind, domain or role (as classified by rs). Clients with an
+// empty value are grouped as unknown.
+func splitClients(clients []*Client, key string, rs *roles) (map[string][]*Client, error) {
+	var value func(c *Client) string
+	switch key {
+	case "role":
+		if rs == nil {
+			return nil, errors.New("role requires -roles")
 		}
+		value = rs.role
+	case "release":
+		value = func(c *Client) string { return string(c.Release) }
+	case "kind":
+		value = func(c *Client) string { return c.Kind() }
+	case "domain":
+		value = func(c *Client) string { return domain(c.FQDN) }
+	default:
+		return nil, fmt.Errorf("unknown key %q", key)
 	}
 
-	return append(clients, add)
+	groups := make(map[string][]*Client)
+	for _, c := range clients {
+		v := fileName(value(c))
+		groups[v] = append(groups[v], c)
+	}
+	return groups, nil
 }
 
-func checkForFeatures(c *Client, feature string) bool {
-	i, err := strconv.ParseInt(trimHexPrefix(c.Feature), 16, 0)
-	if err != nil {
-		log.Fatal(err)
+// fileName returns v as name of a file in the output directory, making sure
+// it can not escape the directory. Empty names are unknown.
+func fileName(v string) string {
+	v = strings.Trim(strings.Replace(v, "/", "_", -1), ".")
+	if v == "" {
+		v = unknown
 	}
+	return v
+}
 
-	b, err := strconv.ParseInt(trimHexPrefix(feature), 16, 0)
+// outputExt returns the file extension of files in the output format.
+func outputExt(format string) string {
+	switch format {
+	case "summary", "report", "table":
+		return ".txt"
+	case "grafana":
+		return ".json"
+	case "ansible-inventory":
+		return ".yaml"
+	}
+	return ".csv"
+}
+
+// writeFile creates the named file and writes to it using fn.
+func writeFile(name string, fn func(w io.Writer) (bool, error)) (bool, error) {
+	f, err := os.Create(name)
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
 
-	return (i & b) != 0
+	ok, err := fn(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return ok, err
 }
 
-func trimHexPrefix(s string) string {
-	return strings.TrimPrefix(s, "0x")
+// tag is a key value pair added as a constant column to the output.
+type tag struct {
+	key   string
+	value string
+}
+
+// tagList implements flag.Value for the repeatable -tag flag.
+type tagList []tag
+
+func (l *tagList) String() string {
+	var s []string
+	for _, t := range *l {
+		s = append(s, t.key+"="+t.value)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *tagList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return errors.New("tag must be in the form key=value")
+	}
+	*l = append(*l, tag{key: s[:i], value: s[i+1:]})
+	return nil
 }
 
-// Client represents a connected client.
-type Client struct {
-	IP      string
-	Feature string
-	Release string
+// labels returns the tags and, if not empty, the fsid as key value pairs,
+// added to the outputs without columns, e.g. as metric labels.
+func (l tagList) labels(fsid string) [][2]string {
+	var labels [][2]string
+	for _, t := range l {
+		labels = append(labels, [2]string{t.key, t.value})
+	}
+	if fsid != "" {
+		labels = append(labels, [2]string{"fsid", fsid})
+	}
+	return labels
 }
 
-func (c *Client) Equal(client *Client) bool {
-	return c.IP == client.IP
+// additionalOutput is an output written by -also.
+type additionalOutput struct {
+	format string
+	file   string
 }
 
-func (c *Client) String() string {
-	return c.IP + c.Feature + c.Release
+// outputList implements flag.Value for the repeatable -also flag.
+type outputList []additionalOutput
+
+func (l *outputList) String() string {
+	var s []string
+	for _, o := range *l {
+		s = append(s, o.format+"="+o.file)
+	}
+	return strings.Join(s, ",")
 }
 
-func (c *Client) UnmarshalJSON(b []byte) error {
-	var str string
-	if err := json.Unmarshal(b, &str); err != nil {
-		return err
+func (l *outputList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 || i == len(s)-1 {
+		return errors.New("output must be in the form format=file")
 	}
+	*l = append(*l, additionalOutput{format: s[:i], file: s[i+1:]})
+	return nil
+}
 
-	// A session string has the following format:
-	// "MonSession(mon.0 10.7.3.65:6789/0 is open allow *, features 0x3ffddff8eea4fffb (luminous))"
-	fields := strings.Split(str, " ")
-	if len(fields) < 9 {
-		return errors.New("unable to parse session string. wrong number of fields")
+// stringList implements flag.Value for repeatable string flags.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// isFlagSet reports whether the named flag was passed on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// checkForFeatures reports whether the client supports feature. If matchAll is
+// set, all bits of the feature mask must be set, otherwise any of them.
+func checkForFeatures(c *Client, feature string, matchAll bool) bool {
+	if !c.Features.Known() {
+		return false
 	}
 
-	host, _, err := net.SplitHostPort(fields[1])
+	b, err := parseFeatureMask(feature)
 	if err != nil {
-		return err
+		log.Fatal(err)
+	}
+
+	if matchAll {
+		return c.Features.Has(uint64(b))
 	}
+	return c.Features&b != 0
+}
 
-	c.IP = host
-	c.Feature = fields[len(fields)-2]
-	c.Release = strings.TrimSuffix(strings.TrimPrefix(fields[len(fields)-1], "("), "))")
+// markMixedReleases sets MixedRelease on all clients whose FQDN is shared with
+// clients reporting a different release, e.g. a host using both a kernel mount
+// and librbd, and logs a warning for each such FQDN.
+func markMixedReleases(clients []*Client) {
+	byName := make(map[string][]*Client)
+	for _, c := range clients {
+		if c.FQDN != "" {
+			byName[c.FQDN] = append(byName[c.FQDN], c)
+		}
+	}
 
-	return nil
+	for name, cs := range byName {
+		var rels []string
+		for _, c := range cs {
+			if !contains(rels, string(c.Release)) {
+				rels = append(rels, string(c.Release))
+			}
+		}
+		if len(rels) < 2 {
+			continue
+		}
+		log.Printf("warning: %s reports different releases: %s\n", name, strings.Join(rels, ", "))
+		for _, c := range cs {
+			c.MixedRelease = true
+		}
+	}
+}
+
+// filterKinds returns only the clients of the given kinds.
+func filterKinds(clients []*Client, kinds []string) []*Client {
+	var filtered []*Client
+	for _, c := range clients {
+		kind := c.Kind()
+		for _, k := range kinds {
+			if strings.TrimSpace(k) == kind {
+				filtered = append(filtered, c)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// addrFamily returns the address family ("ipv4" or "ipv6") of the given IP.
+func addrFamily(ip netip.Addr) string {
+	if ip.Is6() && !ip.Is4In6() {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// mergeDualStackClients merges the clients with the same fqdn into the first
+// of them, listing all of their addresses, if they use both IPv4 and IPv6.
+// Clients of a name using a single family are kept apart.
+func mergeDualStackClients(clients []*Client) []*Client {
+	byName := make(map[string][]*Client)
+	for _, c := range clients {
+		if c.FQDN != "" {
+			byName[c.FQDN] = append(byName[c.FQDN], c)
+		}
+	}
+
+	// Decide before merging, which changes the family.
+	dual := make(map[string]bool)
+	for name, group := range byName {
+		dual[name] = dualStack(group)
+	}
+
+	var merged []*Client
+	for _, c := range clients {
+		group := byName[c.FQDN]
+		if c.FQDN == "" || !dual[c.FQDN] {
+			merged = append(merged, c)
+			continue
+		}
+		if group[0] != c {
+			continue
+		}
+		for _, o := range group[1:] {
+			c.MergedAddrs = append(c.MergedAddrs, o.addrs()...)
+		}
+		c.Family = "dual"
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// dualStack reports whether the clients use both IPv4 and IPv6.
+func dualStack(clients []*Client) bool {
+	var v4, v6 bool
+	for _, c := range clients {
+		switch c.Family {
+		case "ipv4":
+			v4 = true
+		case "ipv6":
+			v6 = true
+		}
+	}
+	return v4 && v6
+}
+
+func trimHexPrefix(s string) string {
+	return strings.TrimPrefix(s, "0x")
 }
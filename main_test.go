@@ -0,0 +1,100 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestCheckForFeatures(t *testing.T) {
+	tests := []struct {
+		features FeatureMask
+		feature  string
+		matchAll bool
+		want     bool
+	}{
+		{0x240000, "0x200000", true, true},
+		{0x240000, "0x200000", false, true},
+		{0x240000, "200000", true, true},
+		{0x040000, "0x200000", true, false},
+		{0x040000, "0x200000", false, false},
+		// combined masks need all bits unless matchAll is disabled
+		{0x240000, "0x240000", true, true},
+		{0x200000, "0x240000", true, false},
+		{0x200000, "0x240000", false, true},
+		{0x000001, "0x240000", false, false},
+		{0x1000000200000, "0x1000000200000", true, true},
+		{0x0000000200000, "0x1000000200000", true, false},
+		{0x0000000200000, "0x1000000200000", false, true},
+		// unknown features never match
+		{0, "0x200000", true, false},
+		{0, "0x200000", false, false},
+		{0, "0x0", true, false},
+	}
+	for _, tt := range tests {
+		c := &Client{Features: tt.features}
+		if got := checkForFeatures(c, tt.feature, tt.matchAll); got != tt.want {
+			t.Errorf("checkForFeatures(%s, %s, matchAll %v) = %v, want %v", tt.features, tt.feature, tt.matchAll, got, tt.want)
+		}
+	}
+}
+
+func TestCheckForResolvedFeatures(t *testing.T) {
+	mask, err := resolveFeature("upmap,crush_v4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	luminous := &Client{Features: 0x3ffddff8eea4fffb}
+	hammer := &Client{Features: 0x1000000040000}
+	if !checkForFeatures(luminous, mask, true) {
+		t.Errorf("luminous client lacks %s", mask)
+	}
+	if checkForFeatures(hammer, mask, true) {
+		t.Errorf("client with crush_v4 only has all of %s", mask)
+	}
+	if !checkForFeatures(hammer, mask, false) {
+		t.Errorf("client with crush_v4 has none of %s", mask)
+	}
+}
+
+func TestMergeDualStackClients(t *testing.T) {
+	client := func(ip, fqdn string) *Client {
+		a := netip.MustParseAddr(ip)
+		return &Client{Addr: a, FQDN: fqdn, Family: addrFamily(a)}
+	}
+	tests := []struct {
+		name string
+		ips  []string
+		want []string
+	}{
+		{"v4 v4 v6", []string{"10.7.3.1", "10.7.3.2", "fd00::1"}, []string{"10.7.3.1 10.7.3.2 fd00::1"}},
+		{"v4 v6 v4", []string{"10.7.3.1", "fd00::1", "10.7.3.2"}, []string{"10.7.3.1 fd00::1 10.7.3.2"}},
+		{"v6 v4", []string{"fd00::1", "10.7.3.1"}, []string{"fd00::1 10.7.3.1"}},
+		{"v4 v4", []string{"10.7.3.1", "10.7.3.2"}, []string{"10.7.3.1", "10.7.3.2"}},
+	}
+	for _, tt := range tests {
+		var clients []*Client
+		for _, ip := range tt.ips {
+			clients = append(clients, client(ip, "a.fqdn.tld."))
+		}
+		clients = append(clients, client("10.7.3.9", ""), client("fd00::9", "b.fqdn.tld."))
+
+		merged := mergeDualStackClients(clients)
+		want := append(tt.want, "10.7.3.9", "fd00::9")
+		if len(merged) != len(want) {
+			t.Errorf("%s: %d clients, want %d: %v", tt.name, len(merged), len(want), merged)
+			continue
+		}
+		for i, c := range merged {
+			if c.IP() != want[i] {
+				t.Errorf("%s: client %d is %q, want %q", tt.name, i, c.IP(), want[i])
+			}
+		}
+		if len(tt.want) == 1 && merged[0].Family != "dual" {
+			t.Errorf("%s: family %s, want dual", tt.name, merged[0].Family)
+		}
+	}
+}
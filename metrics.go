@@ -0,0 +1,152 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// featureSupport returns the number of clients supporting feature, see
+// checkForFeatures.
+func featureSupport(clients []*Client, feature string, matchAll bool) int {
+	n := 0
+	for _, c := range clients {
+		if checkForFeatures(c, feature, matchAll) {
+			n++
+		}
+	}
+	return n
+}
+
+// percent returns n of total in percent, or 0 if total is 0.
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// writeMetrics writes the number of clients per release, the outcome of
+// querying each monitor and, if feature is not empty, the ratio of clients
+// supporting it to the named file in the Prometheus text format, e.g. for the
+// node_exporter textfile collector. The labels (tags and fsid) are added to
+// every sample. The file is replaced atomically so the collector never reads
+// a partial file.
+func writeMetrics(name string, clients []*Client, monitors []monitorResult, feature string, matchAll bool, labels [][2]string) error {
+	var b bytes.Buffer
+
+	fmt.Fprintln(&b, "# HELP ceph_get_clients_clients Number of connected clients.")
+	fmt.Fprintln(&b, "# TYPE ceph_get_clients_clients gauge")
+	fmt.Fprintf(&b, "ceph_get_clients_clients%s %d\n", promLabels(labels), len(clients))
+
+	counts := make(map[string]int)
+	for _, c := range clients {
+		counts[string(c.Release)]++
+	}
+	var rels []string
+	for r := range counts {
+		rels = append(rels, r)
+	}
+	sort.Strings(rels)
+	fmt.Fprintln(&b, "# HELP ceph_get_clients_release_clients Number of connected clients per release.")
+	fmt.Fprintln(&b, "# TYPE ceph_get_clients_release_clients gauge")
+	for _, r := range rels {
+		fmt.Fprintf(&b, "ceph_get_clients_release_clients%s %d\n", promLabels(labels, "release", r), counts[r])
+	}
+
+	if feature != "" && len(clients) > 0 {
+		info, _ := lookupFeature(feature)
+		ratio := float64(featureSupport(clients, feature, matchAll)) / float64(len(clients))
+		fmt.Fprintln(&b, "# HELP ceph_get_clients_feature_supported_ratio Ratio of connected clients supporting the feature.")
+		fmt.Fprintln(&b, "# TYPE ceph_get_clients_feature_supported_ratio gauge")
+		fmt.Fprintf(&b, "ceph_get_clients_feature_supported_ratio%s %g\n", promLabels(labels, "feature", feature, "name", info.Name), ratio)
+	}
+
+	fmt.Fprintln(&b, "# HELP ceph_get_clients_monitor_success Whether the sessions of the monitor were collected successfully.")
+	fmt.Fprintln(&b, "# TYPE ceph_get_clients_monitor_success gauge")
+	for _, m := range monitors {
+		ok := 1
+		if m.Error != "" {
+			ok = 0
+		}
+		fmt.Fprintf(&b, "ceph_get_clients_monitor_success%s %d\n", promLabels(labels, "monitor", m.Host), ok)
+	}
+	fmt.Fprintln(&b, "# HELP ceph_get_clients_monitor_sessions Number of sessions reported by the monitor.")
+	fmt.Fprintln(&b, "# TYPE ceph_get_clients_monitor_sessions gauge")
+	for _, m := range monitors {
+		fmt.Fprintf(&b, "ceph_get_clients_monitor_sessions%s %d\n", promLabels(labels, "monitor", m.Host), m.Sessions)
+	}
+	fmt.Fprintln(&b, "# HELP ceph_get_clients_monitor_connect_seconds Time taken to connect to the monitor.")
+	fmt.Fprintln(&b, "# TYPE ceph_get_clients_monitor_connect_seconds gauge")
+	for _, m := range monitors {
+		fmt.Fprintf(&b, "ceph_get_clients_monitor_connect_seconds%s %g\n", promLabels(labels, "monitor", m.Host), m.ConnectSeconds)
+	}
+	fmt.Fprintln(&b, "# HELP ceph_get_clients_monitor_command_seconds Time taken to retrieve the sessions of the monitor.")
+	fmt.Fprintln(&b, "# TYPE ceph_get_clients_monitor_command_seconds gauge")
+	for _, m := range monitors {
+		fmt.Fprintf(&b, "ceph_get_clients_monitor_command_seconds%s %g\n", promLabels(labels, "monitor", m.Host), m.CommandSeconds)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".metrics")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// promLabels formats the labels followed by the given names and values as
+// Prometheus label set, or returns an empty string if there are none. Label
+// names are sanitized, characters other than letters, digits and underscores
+// are replaced by underscores.
+func promLabels(labels [][2]string, kv ...string) string {
+	var l []string
+	for _, p := range labels {
+		l = append(l, fmt.Sprintf("%s=%s", promLabelName(p[0]), promQuote(p[1])))
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		l = append(l, fmt.Sprintf("%s=%s", kv[i], promQuote(kv[i+1])))
+	}
+	if len(l) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(l, ",") + "}"
+}
+
+// promLabelName returns s as valid Prometheus label name.
+func promLabelName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		letter := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// promQuote quotes a label value, escaping backslashes, double quotes and
+// newlines as required by the Prometheus text format.
+func promQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
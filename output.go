@@ -0,0 +1,353 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// column describes a single output column.
+type column struct {
+	name  string
+	value func(c *Client) string
+}
+
+// defaultColumns returns the columns which are always part of the output.
+func defaultColumns() []column {
+	return []column{
+		{"IP", func(c *Client) string { return c.IP() }},
+		{"feature", func(c *Client) string { return c.Features.String() }},
+		{"release", func(c *Client) string { return string(c.Release) }},
+		{"fqdn", func(c *Client) string { return c.FQDN }},
+		{"domain", func(c *Client) string { return domain(c.FQDN) }},
+		{"family", func(c *Client) string { return c.Family }},
+		{"entity", func(c *Client) string { return string(c.Entity) }},
+		{"global_id", func(c *Client) string {
+			if c.GlobalID == 0 {
+				return ""
+			}
+			return strconv.FormatUint(c.GlobalID, 10)
+		}},
+		{"global_id_status", func(c *Client) string { return c.GlobalIDStatus }},
+		{"state", func(c *Client) string { return string(c.State) }},
+		{"kind", func(c *Client) string { return c.Kind() }},
+		{"implementation", func(c *Client) string { return c.Implementation() }},
+		{"mixed_release", func(c *Client) string { return fmt.Sprint(c.MixedRelease) }},
+		{"msgr", func(c *Client) string { return c.Msgr }},
+	}
+}
+
+// constColumn returns a column with the same value for every client.
+func constColumn(name, value string) column {
+	return column{name, func(*Client) string { return value }}
+}
+
+// writeCSV writes the given columns of all clients as CSV to w, optionally
+// preceded by a header row. If crlf is set, lines are terminated by \r\n.
+func writeCSV(w io.Writer, cols []column, clients []*Client, header, crlf bool) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = crlf
+
+	if header {
+		names := make([]string, len(cols))
+		for i, col := range cols {
+			names[i] = col.name
+		}
+		cw.Write(names)
+	}
+
+	for _, c := range clients {
+		line := make([]string, len(cols))
+		for i, col := range cols {
+			line[i] = csvValue(col.value(c))
+		}
+		cw.Write(line)
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// writeTable writes the given columns of all clients as an aligned table to w,
+// translating the names of the default columns in the header using msg.
+// Other columns, e.g. tags, keep their names.
+// If color is set, the release column is colored red for pre-luminous,
+// yellow for pre-nautilus and green for all newer releases.
+func writeTable(w io.Writer, cols []column, clients []*Client, header, color bool, msg messages) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.StripEscape)
+
+	if header {
+		builtin := make(map[string]bool)
+		for _, col := range defaultColumns() {
+			builtin[col.name] = true
+		}
+		names := make([]string, len(cols))
+		for i, col := range cols {
+			names[i] = col.name
+			if builtin[col.name] {
+				names[i] = msg.text(col.name)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(names, "\t"))
+	}
+
+	for _, c := range clients {
+		line := make([]string, len(cols))
+		for i, col := range cols {
+			line[i] = tableValue(col.value(c))
+			if color && col.name == "release" {
+				line[i] = colorRelease(line[i])
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(line, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// grafanaTable is a table in the response format of the Grafana JSON
+// datasource, which the Infinity datasource reads as well.
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]string      `json:"rows"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// writeGrafana writes the given columns of all clients as a single table in
+// the Grafana JSON datasource format to w.
+func writeGrafana(w io.Writer, cols []column, clients []*Client) error {
+	t := grafanaTable{Type: "table", Rows: make([][]string, 0, len(clients))}
+	for _, col := range cols {
+		t.Columns = append(t.Columns, grafanaColumn{Text: col.name, Type: "string"})
+	}
+	for _, c := range clients {
+		row := make([]string, len(cols))
+		for i, col := range cols {
+			row[i] = col.value(c)
+		}
+		t.Rows = append(t.Rows, row)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode([]grafanaTable{t})
+}
+
+// ansibleGroup is a group of an Ansible YAML inventory.
+type ansibleGroup struct {
+	Hosts map[string]ansibleHost `yaml:"hosts"`
+}
+
+type ansibleHost struct {
+	AnsibleHost string `yaml:"ansible_host,omitempty"`
+	Release     string `yaml:"ceph_release"`
+}
+
+// ansibleAll is the top level group of an Ansible inventory.
+type ansibleAll struct {
+	Children map[string]ansibleGroup `yaml:"children"`
+	Vars     map[string]string       `yaml:"vars,omitempty"`
+}
+
+// writeAnsibleInventory writes the clients as Ansible YAML inventory to w,
+// grouped by release (release_<name>) and DNS domain (domain_<name>). Clients
+// are named by their first fqdn, or by their IP if they have none. The labels
+// (tags and fsid) are added as variables of all hosts.
+func writeAnsibleInventory(w io.Writer, clients []*Client, labels [][2]string) error {
+	groups := make(map[string]ansibleGroup)
+	add := func(group, name string, h ansibleHost) {
+		g, ok := groups[group]
+		if !ok {
+			g = ansibleGroup{Hosts: make(map[string]ansibleHost)}
+			groups[group] = g
+		}
+		g.Hosts[name] = h
+	}
+
+	for _, c := range clients {
+		name := c.Addr.String()
+		h := ansibleHost{Release: string(c.Release)}
+		if names := strings.Fields(c.FQDN); len(names) > 0 {
+			name = normalizeName(names[0])
+			h.AnsibleHost = c.Addr.String()
+		}
+		add("release_"+ansibleGroupName(string(c.Release)), name, h)
+		if d := domain(c.FQDN); d != "" {
+			add("domain_"+ansibleGroupName(d), name, h)
+		}
+	}
+
+	all := ansibleAll{Children: groups}
+	for _, l := range labels {
+		if all.Vars == nil {
+			all.Vars = make(map[string]string)
+		}
+		all.Vars[ansibleGroupName(l[0])] = l[1]
+	}
+	inv := map[string]ansibleAll{"all": all}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(inv); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// ansibleGroupName replaces all characters not allowed in Ansible group
+// names by underscores.
+func ansibleGroupName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// csvValue prefixes values which spreadsheets would evaluate as formula, e.g.
+// a name from a PTR record starting with =, with a single quote.
+func csvValue(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// tableValue replaces control characters, which would break the alignment of
+// the table or inject terminal escape sequences, and invalid UTF-8 including
+// the tabwriter escape character.
+func tableValue(s string) string {
+	s = strings.ToValidUTF8(s, "?")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// jsonList returns the space separated values of s as JSON array, e.g. for the
+// names of clients with multiple PTR records.
+func jsonList(s string) string {
+	l := strings.Fields(s)
+	if l == nil {
+		l = []string{}
+	}
+	b, _ := json.Marshal(l)
+	return string(b)
+}
+
+// ANSI escape sequences, wrapped in tabwriter.Escape so they do not count
+// towards the column width.
+const (
+	ansiRed    = "\xff\x1b[31m\xff"
+	ansiYellow = "\xff\x1b[33m\xff"
+	ansiGreen  = "\xff\x1b[32m\xff"
+	ansiReset  = "\xff\x1b[0m\xff"
+)
+
+func colorRelease(release string) string {
+	color := ansiGreen
+	switch r := parseRelease(release); {
+	case r.Less("luminous"):
+		color = ansiRed
+	case r.Less("nautilus"):
+		color = ansiYellow
+	}
+	return color + release + ansiReset
+}
+
+// useColor reports whether colored output should be written to w, which is
+// the case if w is a terminal and NO_COLOR is not set.
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// redact replaces the value of the named columns by an empty string or, if
+// key is set, by a short HMAC-SHA256 of the value, so equal values can still
+// be grouped without the values being recoverable by hashing all candidates,
+// e.g. the IPv4 address space.
+func redact(cols []column, names []string, key []byte) ([]column, error) {
+	for _, name := range names {
+		i := columnIndex(cols, strings.TrimSpace(name))
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+
+		value := cols[i].value
+		cols[i].value = func(c *Client) string {
+			v := value(c)
+			if key == nil || v == "" {
+				return ""
+			}
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(v))
+			return hex.EncodeToString(mac.Sum(nil)[:6])
+		}
+	}
+	return cols, nil
+}
+
+// redactKey returns the key of -redact-hash read from the named file or, if
+// name is empty, a random key, so hashes are only comparable within a run.
+func redactKey(name string) ([]byte, error) {
+	if name == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	key, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(key)) == 0 {
+		return nil, fmt.Errorf("%s is empty", name)
+	}
+	return key, nil
+}
+
+// columnIndex returns the index of the column with the given name, matched
+// case insensitive, or -1 if there is none.
+func columnIndex(cols []column, name string) int {
+	for i, col := range cols {
+		if strings.EqualFold(col.name, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestWriteCSVHostnames(t *testing.T) {
+	tests := []struct {
+		fqdn string
+		want string
+	}{
+		{"mon1.lab.tld.", "10.7.3.67,mon1.lab.tld.\n"},
+		{"a.tld. b.tld.", "10.7.3.67,a.tld. b.tld.\n"},
+		{"a,b.tld.", "10.7.3.67,\"a,b.tld.\"\n"},
+		{`a"b.tld.`, "10.7.3.67,\"a\"\"b.tld.\"\n"},
+		{"a\nb.tld.", "10.7.3.67,\"a\nb.tld.\"\n"},
+		{"a\r\nb.tld.", "10.7.3.67,\"a\r\nb.tld.\"\n"},
+		{" a.tld.", "10.7.3.67,\" a.tld.\"\n"},
+		{"=cmd|' /C calc'!A0", "10.7.3.67,'=cmd|' /C calc'!A0\n"},
+		{"+1+1", "10.7.3.67,'+1+1\n"},
+		{"-1+1", "10.7.3.67,'-1+1\n"},
+		{"@SUM(A1)", "10.7.3.67,'@SUM(A1)\n"},
+		{"\t=1", "10.7.3.67,'\t=1\n"},
+		{"\r=1", "10.7.3.67,\"'\r=1\"\n"},
+		{`=HYPERLINK("http://x","y")`, "10.7.3.67,\"'=HYPERLINK(\"\"http://x\"\",\"\"y\"\")\"\n"},
+		{"a=b.tld.", "10.7.3.67,a=b.tld.\n"},
+	}
+	cols := []column{
+		{"IP", func(c *Client) string { return c.IP() }},
+		{"fqdn", func(c *Client) string { return c.FQDN }},
+	}
+	for _, tt := range tests {
+		c := &Client{Addr: netip.MustParseAddr("10.7.3.67"), FQDN: tt.fqdn}
+		var buf bytes.Buffer
+		if err := writeCSV(&buf, cols, []*Client{c}, false, false); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("fqdn %q: got %q, want %q", tt.fqdn, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCSVHeader(t *testing.T) {
+	cols := []column{
+		{"IP", func(c *Client) string { return c.IP() }},
+		{"note", func(c *Client) string { return "-" }},
+	}
+	c := &Client{Addr: netip.MustParseAddr("10.7.3.67")}
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, cols, []*Client{c}, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if want := "IP,note\r\n10.7.3.67,'-\r\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteTableHeader(t *testing.T) {
+	msg, err := lookupMessages("de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := []column{
+		{"IP", func(c *Client) string { return c.IP() }},
+		{"release", func(c *Client) string { return string(c.Release) }},
+		// A -tag, -resolve-cmd and -annotations column.
+		constColumn("site", "x"),
+		constColumn("resolved", "rack1"),
+		constColumn("note", "vm"),
+	}
+	clients := []*Client{{Addr: netip.MustParseAddr("10.7.3.67"), Release: "luminous"}}
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, cols, clients, true, false, msg); err != nil {
+		t.Fatal(err)
+	}
+	want := "IP         Release   site  resolved  note\n10.7.3.67  luminous  x     rack1     vm\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeTable =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// domainOwners maps DNS domains to the team owning the clients within. It is
+// read from a YAML file of the following form, the longest matching domain
+// wins:
+//
+//	owners:
+//	  fqdn.tld: infra
+//	  lab.fqdn.tld: lab-team
+type domainOwners map[string]string
+
+func readDomainOwners(name string) (domainOwners, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f struct {
+		Owners map[string]string `yaml:"owners"`
+	}
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	o := make(domainOwners)
+	for d, owner := range f.Owners {
+		o[normalizeName(d)] = owner
+	}
+	return o, nil
+}
+
+// owner returns the owner of the given domain or an empty string.
+func (o domainOwners) owner(domain string) string {
+	for d := normalizeName(domain); d != ""; {
+		if owner, ok := o[d]; ok {
+			return owner
+		}
+		i := strings.Index(d, ".")
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	return ""
+}
+
+// domain returns the DNS domain of the first name in fqdn, which is the name
+// without its first label.
+func domain(fqdn string) string {
+	names := strings.Fields(fqdn)
+	if len(names) == 0 {
+		return ""
+	}
+	name := normalizeName(names[0])
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// readOnlyCommands lists the remote commands ceph-get-clients may execute in
+// read-only mode. A command is allowed if all of its words match one of the
+// entries, where "*" matches any single word not starting with "-". A leading
+// sudo (and sudo -n) and the global ceph options --format and --cluster are
+// ignored, 'sudo -l <command>' and 'cephadm shell --name <daemon> --
+// <command>' are checked like the command itself.
+var readOnlyCommands = [][]string{
+	{"ceph", "fsid"},
+	{"ceph", "daemon", "*", "sessions"},
+	{"ceph", "tell", "*", "sessions"},
+	{"ceph", "status"},
+	{"ceph", "-s"},
+	{"ceph", "health", "detail"},
+	{"ceph", "df"},
+	{"ceph", "versions"},
+	{"ceph", "features"},
+	{"ceph", "quorum_status"},
+	{"ceph", "mon", "dump"},
+	{"ceph", "mon", "stat"},
+	{"ceph", "mgr", "dump"},
+	{"ceph", "osd", "dump"},
+	{"ceph", "osd", "tree"},
+	{"ceph", "osd", "df"},
+	{"ceph", "osd", "ls"},
+	{"ceph", "osd", "pool", "ls"},
+	{"ceph", "pg", "stat"},
+	{"ceph", "fs", "ls"},
+	{"ceph", "fs", "status"},
+	{"rbd", "ls", "--format", "json", "*"},
+	{"rbd", "status", "--format", "json", "*"},
+	{"ls", socketDir},
+	{"true"},
+}
+
+// readOnlyFilters lists the commands output may be piped to in read-only mode.
+// Commands which can write files, like sort -o, are not included.
+var readOnlyFilters = []string{"head", "tail", "grep", "wc", "cut"}
+
+// readOnlyRunner refuses to execute commands which are not known to be
+// read-only before passing them on to the wrapped runner.
+type readOnlyRunner struct {
+	r runner
+	// extra are additional allowed commands, e.g. a configured remote
+	// wrapper.
+	extra [][]string
+}
+
+func (ro *readOnlyRunner) Run(host, cmd string) ([]byte, error) {
+	if err := ro.check(cmd); err != nil {
+		return nil, err
+	}
+	return ro.r.Run(host, cmd)
+}
+
+func (ro *readOnlyRunner) connectTime(host string) time.Duration {
+	if ct, ok := ro.r.(connectTimer); ok {
+		return ct.connectTime(host)
+	}
+	return 0
+}
+
+// check returns an error if cmd is not allowed in read-only mode.
+func (ro *readOnlyRunner) check(cmd string) error {
+	if strings.ContainsAny(cmd, ";&<>`$\n") {
+		return fmt.Errorf("read-only mode: refusing to run %q: shell control characters", cmd)
+	}
+
+	parts := strings.Split(cmd, "|")
+	if !matchCommand(strings.Fields(parts[0]), readOnlyCommands) && !matchCommand(strings.Fields(parts[0]), ro.extra) {
+		return fmt.Errorf("read-only mode: refusing to run %q: not an allowed command", cmd)
+	}
+	for _, p := range parts[1:] {
+		f := strings.Fields(p)
+		if len(f) == 0 || !contains(readOnlyFilters, f[0]) {
+			return fmt.Errorf("read-only mode: refusing to run %q: not an allowed filter", cmd)
+		}
+	}
+	return nil
+}
+
+// matchCommand reports whether the command words match one of the allowed
+// commands.
+func matchCommand(words []string, allowed [][]string) bool {
+	if len(words) > 0 && words[0] == "sudo" {
+		words = words[1:]
+		if len(words) > 0 && words[0] == "-n" {
+			words = words[1:]
+		}
+		// sudo -l only lists whether the command may be run.
+		if len(words) > 0 && words[0] == "-l" {
+			words = words[1:]
+		}
+	}
+	if len(words) > 0 && words[0] == "cephadm" {
+		if len(words) < 6 || words[1] != "shell" || words[2] != "--name" || !isArg(words[3]) || words[4] != "--" {
+			return false
+		}
+		return matchCommand(words[5:], allowed)
+	}
+	if len(words) > 0 && words[0] == "ceph" {
+		var ok bool
+		if words, ok = stripCephOptions(words); !ok {
+			return false
+		}
+	}
+
+	for _, a := range allowed {
+		if matchWords(words, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWords reports whether words match the allowed command a.
+func matchWords(words, a []string) bool {
+	if len(words) != len(a) {
+		return false
+	}
+	for i, w := range a {
+		if w == "*" && !isArg(words[i]) || w != "*" && w != words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isArg reports whether w is an argument and not an option.
+func isArg(w string) bool {
+	return w != "" && !strings.HasPrefix(w, "-")
+}
+
+// stripCephOptions removes the global --format and --cluster options from a
+// ceph command line. It reports false if an option is missing its value.
+func stripCephOptions(words []string) ([]string, bool) {
+	out := []string{words[0]}
+	for i := 1; i < len(words); i++ {
+		w := words[i]
+		switch {
+		case w == "--format" || w == "-f" || w == "--cluster":
+			i++
+			if i == len(words) || !isArg(words[i]) {
+				return nil, false
+			}
+		case strings.HasPrefix(w, "--format=") || strings.HasPrefix(w, "--cluster="):
+		default:
+			out = append(out, w)
+		}
+	}
+	return out, true
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
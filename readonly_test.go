@@ -0,0 +1,97 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyCheck(t *testing.T) {
+	ro := &readOnlyRunner{extra: [][]string{
+		{"/usr/local/bin/mon-sessions"},
+		{"/usr/local/bin/mon-sessions", "*"},
+		strings.Fields(globCommand("/var/run/ceph/*/ceph-mon.*.asok")),
+	}}
+
+	allowed := []string{
+		"sudo -n true",
+		"sudo ceph fsid",
+		"sudo ceph --format json daemon /var/run/ceph/ceph-mon.a.asok sessions",
+		"sudo ceph daemon mon.a sessions",
+		"ceph tell mon.a sessions --format json",
+		"sudo ceph --cluster backup --format=json daemon mon.a sessions",
+		"sudo ceph quorum_status --format json",
+		"ceph health detail",
+		"sudo ls /var/run/ceph",
+		"sudo sh -c 'ls -d /var/run/ceph/*/ceph-mon.*.asok'",
+		"sudo rbd ls --format json rbd",
+		"sudo rbd status --format json rbd/vm-1",
+		"sudo cephadm shell --name mon.a -- ceph --format json daemon mon.a sessions",
+		"sudo /usr/local/bin/mon-sessions a",
+		"sudo -n -l /usr/local/bin/mon-sessions",
+		"ceph status | grep health",
+		"ceph df | head -n 5 | cut -c 1-80",
+	}
+	for _, cmd := range allowed {
+		if err := ro.check(cmd); err != nil {
+			t.Errorf("check(%q) = %v, want nil", cmd, err)
+		}
+	}
+
+	rejected := []string{
+		"",
+		"sudo",
+		"rm -rf /",
+		"sudo -l",
+		"sudo -n -l",
+		"sudo -l rm -rf /",
+		"sudo -n -l -U root",
+		"ceph health",
+		"ceph health detail --connect-timeout 1",
+		"ceph osd pool ls; rm -rf /",
+		"ceph status && reboot",
+		"ceph status `reboot`",
+		"ceph status $(reboot)",
+		"ceph status > /etc/passwd",
+		"ceph status\nreboot",
+		"ceph osd pool delete rbd rbd --yes-i-really-really-mean-it",
+		"ceph osd out 0",
+		"ceph config set mon foo bar",
+		"ceph daemon mon.a config set debug_mon 20",
+		"ceph daemon --admin-daemon sessions",
+		"ceph --format",
+		"ceph --cluster --conf status",
+		"ceph --conf /tmp/evil.conf status",
+		"ls /",
+		"ls /var/run/ceph /etc",
+		"ls -d /etc/*",
+		"sudo ls -d /var/run/ceph/*/ceph-mon.*.asok",
+		"sudo sh -c 'ls -d /etc/*'",
+		"sudo sh -c 'ls -d /var/run/ceph/*/ceph-mon.*.asok /etc'",
+		"sudo sh -c 'id'",
+		"rbd rm rbd/vm-1",
+		"rbd ls --format json --pool rbd",
+		"cephadm rm-cluster --fsid x --force",
+		"cephadm shell -- ceph status",
+		"cephadm shell --name mon.a --mount /:/host -- ceph status",
+		"cephadm shell --name mon.a -- rm -rf /",
+		"sudo /usr/local/bin/mon-sessions a b",
+		"sudo /usr/local/bin/mon-sessions --help",
+		"ceph status | sort -o /etc/passwd",
+		"ceph status | sort --output=/etc/passwd",
+		"ceph status | sort --compress-program=sh",
+		"ceph status | uniq - /etc/passwd",
+		"ceph status | tee /etc/passwd",
+		"ceph status | sh",
+		"ceph status |",
+		"ceph status || reboot",
+	}
+	for _, cmd := range rejected {
+		if err := ro.check(cmd); err == nil {
+			t.Errorf("check(%q) = nil, want an error", cmd)
+		}
+	}
+}
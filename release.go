@@ -0,0 +1,97 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// releases lists the Ceph releases from oldest to newest.
+var releases = []string{
+	"argonaut",
+	"bobtail",
+	"cuttlefish",
+	"dumpling",
+	"emperor",
+	"firefly",
+	"giant",
+	"hammer",
+	"infernalis",
+	"jewel",
+	"kraken",
+	"luminous",
+	"mimic",
+	"nautilus",
+	"octopus",
+	"pacific",
+	"quincy",
+	"reef",
+	"squid",
+	"tentacle",
+}
+
+// Release is the name of a Ceph release such as luminous. Releases are ordered
+// by release history, unknown releases are older than any known release.
+type Release string
+
+// parseRelease returns the release with the given name, which is matched case
+// insensitively. An empty name is an unknown release.
+func parseRelease(name string) Release {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return unknown
+	}
+	return Release(name)
+}
+
+// Rank returns the position of the release in the release history, starting
+// at 1 for the oldest release. Unknown releases have rank 0.
+func (r Release) Rank() int {
+	name := strings.ToLower(string(r))
+	for i, rel := range releases {
+		if rel == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Known reports whether r is a known release.
+func (r Release) Known() bool {
+	return r.Rank() > 0
+}
+
+// Compare returns a negative number if r is older than o, a positive one if it
+// is newer and zero if both are the same release. Unknown releases are ordered
+// by name.
+func (r Release) Compare(o Release) int {
+	if d := r.Rank() - o.Rank(); d != 0 {
+		return d
+	}
+	return strings.Compare(string(r), string(o))
+}
+
+// Less reports whether r is older than o.
+func (r Release) Less(o Release) bool {
+	return r.Compare(o) < 0
+}
+
+// sortReleases sorts releases from the oldest to the newest.
+func sortReleases(rels []Release) {
+	sort.Slice(rels, func(i, j int) bool { return rels[i].Less(rels[j]) })
+}
+
+// sortByRelease sorts the clients from the oldest to the newest release. Clients
+// with the same release are ordered by their feature mask.
+func sortByRelease(clients []*Client) {
+	sort.SliceStable(clients, func(i, j int) bool {
+		ri, rj := clients[i].Release.Rank(), clients[j].Release.Rank()
+		if ri != rj {
+			return ri < rj
+		}
+		return clients[i].Features < clients[j].Features
+	})
+}
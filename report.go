@@ -0,0 +1,56 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// runReport holds metadata about a single collection run. It is written as
+// JSON to the file given by -run-report.
+type runReport struct {
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Monitors []monitorResult `json:"monitors"`
+	Clients  int             `json:"clients"`
+	Output   *outputDigest   `json:"output,omitempty"`
+
+	ExtraCommands []commandOutput `json:"extra_commands,omitempty"`
+}
+
+// commandOutput is the output of an extra command run on a monitor.
+type commandOutput struct {
+	Host    string `json:"host,omitempty"`
+	Command string `json:"command"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runExtra runs cmd on the first of the given hosts on which it succeeds.
+func runExtra(r runner, hosts []string, cmd string) commandOutput {
+	co := commandOutput{Command: cmd}
+	for _, h := range hosts {
+		out, err := r.Run(h, cmd)
+		co.Host = h
+		if err != nil {
+			co.Error = err.Error()
+			continue
+		}
+		co.Output = string(out)
+		co.Error = ""
+		break
+	}
+	return co
+}
+
+func (rep *runReport) write(name string) error {
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cmdResolver enriches clients using a site specific command, run locally
+// once per IP with {ip} replaced by the address of the client. Its output is
+// stored in Client.Resolved.
+type cmdResolver struct {
+	cmd      string
+	parallel int
+	timeout  time.Duration
+}
+
+// resolveAll runs the command for all clients, up to parallel at once.
+func (r *cmdResolver) resolveAll(clients []*Client) {
+	n := r.parallel
+	if n < 1 {
+		n = 1
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, n)
+	)
+	for _, c := range clients {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			c.Resolved = r.resolve(c.Addr.String())
+		}(c)
+	}
+	wg.Wait()
+}
+
+// resolve returns the output of the command for ip with all white space
+// collapsed, or an empty string if it failed.
+func (r *cmdResolver) resolve(ip string) string {
+	// IPs never need quoting, quote anyway so the command is never
+	// interpreted beyond the placeholder.
+	cmd := exec.Command("sh", "-c", strings.ReplaceAll(r.cmd, "{ip}", "'"+ip+"'"))
+	// Children of the shell may keep stdout open after the shell is
+	// killed, read it from a pipe which can be closed on a timeout instead
+	// of letting cmd.Wait copy the output.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		log.Printf("unable to resolve %s using -resolve-cmd: %v\n", ip, err)
+		return ""
+	}
+	defer pr.Close()
+	cmd.Stdout = pw
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		log.Printf("unable to resolve %s using -resolve-cmd: %v\n", ip, err)
+		return ""
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	read := make(chan []byte, 1)
+	go func() {
+		out, _ := ioutil.ReadAll(pr)
+		read <- out
+	}()
+	var timeout <-chan time.Time
+	if r.timeout > 0 {
+		t := time.NewTimer(r.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var out []byte
+	select {
+	case err = <-done:
+	case <-timeout:
+		err = fmt.Errorf("timed out after %v", r.timeout)
+	}
+	if err == nil {
+		select {
+		case out = <-read:
+		case <-timeout:
+			err = fmt.Errorf("timed out after %v waiting for the output", r.timeout)
+		}
+	}
+	if err != nil {
+		// Closing the pipe ends the read, killing the shell the wait.
+		cmd.Process.Kill()
+		pr.Close()
+		log.Printf("unable to resolve %s using -resolve-cmd: %v\n", ip, err)
+		return ""
+	}
+	return strings.Join(strings.Fields(string(out)), " ")
+}
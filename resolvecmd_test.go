@@ -0,0 +1,50 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCmdResolver(t *testing.T) {
+	r := &cmdResolver{cmd: "echo host of {ip}; echo '  rack 1 '", timeout: 5 * time.Second}
+	if got, want := r.resolve("10.7.3.67"), "host of 10.7.3.67 rack 1"; got != want {
+		t.Errorf("resolve = %q, want %q", got, want)
+	}
+	r.cmd = "echo partial; exit 1"
+	if got := r.resolve("10.7.3.67"); got != "" {
+		t.Errorf("resolve of failing command = %q, want empty", got)
+	}
+}
+
+func TestCmdResolverTimeout(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for _, cmd := range []string{
+		// The shell is killed, sleep keeps stdout open.
+		"sleep 5; echo {ip}",
+		// The shell exits, sleep keeps stdout open.
+		"echo {ip}; sleep 5 &",
+	} {
+		r := &cmdResolver{cmd: cmd, timeout: 100 * time.Millisecond}
+		start := time.Now()
+		if got := r.resolve("10.7.3.67"); got != "" {
+			t.Errorf("%s: resolve = %q, want empty", cmd, got)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: resolve returned after %v, want about %v", cmd, d, r.timeout)
+		}
+	}
+
+	// No goroutine must be left waiting for the output.
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left after timeouts", n-before)
+	}
+}
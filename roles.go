@@ -0,0 +1,86 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/netip"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Client roles.
+const (
+	roleInfrastructure = "infrastructure"
+	roleTenant         = "tenant"
+)
+
+// roles classifies clients as infrastructure (hypervisors, backup servers,
+// ...) or tenant clients. It is read from a YAML file listing the
+// infrastructure clients as IP addresses, networks in CIDR notation or
+// patterns matching the entity or fully qualified domain name, where "*"
+// matches any sequence of characters. All other clients are tenants.
+//
+//	infrastructure:
+//	  - 10.7.3.0/24
+//	  - client.backup*
+//	  - hv*.fqdn.tld
+type roles struct {
+	Infrastructure []string `yaml:"infrastructure"`
+
+	ips      map[netip.Addr]bool
+	nets     []netip.Prefix
+	patterns []string
+}
+
+func readRoles(name string) (*roles, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &roles{ips: make(map[netip.Addr]bool)}
+	if err := yaml.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	for _, e := range r.Infrastructure {
+		if n, err := netip.ParsePrefix(e); err == nil {
+			r.nets = append(r.nets, n.Masked())
+			continue
+		}
+		if ip, err := netip.ParseAddr(e); err == nil {
+			r.ips[ip] = true
+			continue
+		}
+		p := normalizeName(e)
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q", name, e)
+		}
+		r.patterns = append(r.patterns, p)
+	}
+
+	return r, nil
+}
+
+// role returns the role of the client.
+func (r *roles) role(c *Client) string {
+	if matchAddrs(c, r.ips, r.nets) {
+		return roleInfrastructure
+	}
+
+	names := append([]string{string(c.Entity)}, strings.Fields(c.FQDN)...)
+	for _, p := range r.patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(p, normalizeName(name)); ok {
+				return roleInfrastructure
+			}
+		}
+	}
+	return roleTenant
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signatureNamespace is the namespace of output signatures, as passed to
+// 'ssh-keygen -Y verify -n'.
+const signatureNamespace = "file"
+
+// outputDigest identifies the written output in the run report, so archived
+// reports can be verified as unmodified.
+type outputDigest struct {
+	File      string `json:"file"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// digestOutput returns the digest of the file name and, if signer is not nil,
+// writes its signature to name.sig.
+func digestOutput(name string, signer ssh.Signer) (*outputDigest, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	d := &outputDigest{File: name, SHA256: hex.EncodeToString(sum[:])}
+	if signer == nil {
+		return d, nil
+	}
+
+	sig, err := sshSignature(signer, signatureNamespace, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(name+".sig", sig, 0644); err != nil {
+		return nil, err
+	}
+	d.Signature = string(sig)
+	return d, nil
+}
+
+// readSigner reads an unencrypted SSH private key.
+func readSigner(name string) (ssh.Signer, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+// sshSignature returns the signature of message in the armored format of
+// 'ssh-keygen -Y sign' (PROTOCOL.sshsig of OpenSSH).
+func sshSignature(signer ssh.Signer, namespace string, message []byte) ([]byte, error) {
+	const magic = "SSHSIG"
+	h := sha512.Sum512(message)
+
+	signed := struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      string
+	}{namespace, "", "sha512", string(h[:])}
+	data := append([]byte(magic), ssh.Marshal(signed)...)
+
+	var (
+		sig *ssh.Signature
+		err error
+	)
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-keygen refuses SHA-1 RSA signatures.
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := struct {
+		Version   uint32
+		PublicKey string
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature string
+	}{1, string(signer.PublicKey().Marshal()), namespace, "", "sha512", string(ssh.Marshal(sig))}
+	enc := base64.StdEncoding.EncodeToString(append([]byte(magic), ssh.Marshal(blob)...))
+
+	var b strings.Builder
+	b.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(enc) > 70 {
+		b.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	b.WriteString(enc + "\n-----END SSH SIGNATURE-----\n")
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHSignature(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("IP,feature,release\n10.7.3.67,0x3ffddff8eea4fffb,luminous\n")
+	for _, key := range []interface{}{edKey, rsaKey} {
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ := signer.PublicKey().Type()
+
+		armored, err := sshSignature(signer, signatureNamespace, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := string(armored)
+		if !strings.HasPrefix(s, "-----BEGIN SSH SIGNATURE-----\n") || !strings.HasSuffix(s, "\n-----END SSH SIGNATURE-----\n") {
+			t.Fatalf("%s: not armored:\n%s", typ, s)
+		}
+		lines := strings.Split(strings.TrimSpace(s), "\n")
+		for _, l := range lines[1 : len(lines)-1] {
+			if len(l) > 70 {
+				t.Errorf("%s: line longer than 70 characters: %s", typ, l)
+			}
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-1], ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(raw, []byte("SSHSIG")) {
+			t.Fatalf("%s: missing magic", typ)
+		}
+
+		var blob struct {
+			Version   uint32
+			PublicKey string
+			Namespace string
+			Reserved  string
+			HashAlg   string
+			Signature string
+		}
+		if err := ssh.Unmarshal(raw[len("SSHSIG"):], &blob); err != nil {
+			t.Fatal(err)
+		}
+		if blob.Version != 1 || blob.Namespace != signatureNamespace || blob.HashAlg != "sha512" || blob.Reserved != "" {
+			t.Errorf("%s: version %d, namespace %q, hash %q", typ, blob.Version, blob.Namespace, blob.HashAlg)
+		}
+		if !bytes.Equal([]byte(blob.PublicKey), signer.PublicKey().Marshal()) {
+			t.Errorf("%s: wrong public key", typ)
+		}
+
+		var sig ssh.Signature
+		if err := ssh.Unmarshal([]byte(blob.Signature), &sig); err != nil {
+			t.Fatal(err)
+		}
+		if typ == ssh.KeyAlgoRSA && sig.Format != ssh.SigAlgoRSASHA2512 {
+			t.Errorf("RSA signature format %s, want %s", sig.Format, ssh.SigAlgoRSASHA2512)
+		}
+
+		h := sha512.Sum512(message)
+		signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+			Namespace string
+			Reserved  string
+			HashAlg   string
+			Hash      string
+		}{signatureNamespace, "", "sha512", string(h[:])})...)
+		if err := signer.PublicKey().Verify(signed, &sig); err != nil {
+			t.Errorf("%s: signature does not verify: %v", typ, err)
+		}
+		if err := signer.PublicKey().Verify(append(signed, 'x'), &sig); err == nil {
+			t.Errorf("%s: signature verifies modified data", typ)
+		}
+
+		verifyWithSSHKeygen(t, signer.PublicKey(), message, armored)
+	}
+}
+
+// verifyWithSSHKeygen checks the signature using 'ssh-keygen -Y verify' if
+// ssh-keygen is installed.
+func verifyWithSSHKeygen(t *testing.T, pub ssh.PublicKey, message, sig []byte) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		return
+	}
+
+	dir := t.TempDir()
+	signers := filepath.Join(dir, "allowed_signers")
+	sigFile := filepath.Join(dir, "clients.csv.sig")
+	if err := ioutil.WriteFile(signers, append([]byte("reports "), ssh.MarshalAuthorizedKey(pub)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(sigFile, sig, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", signers, "-I", "reports", "-n", signatureNamespace, "-s", sigFile)
+	cmd.Stdin = bytes.NewReader(message)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("ssh-keygen -Y verify %s: %v\n%s", pub.Type(), err, out)
+	}
+}
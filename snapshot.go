@@ -0,0 +1,102 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// snapshot is the client population of a run as stored on disk, used to
+// compare runs with each other.
+type snapshot struct {
+	// Version is the schema version of the snapshot, see snapshotVersion.
+	Version int              `json:"version"`
+	Clients []snapshotClient `json:"clients"`
+}
+
+// snapshotVersion is the schema version of written snapshots. Increase it
+// when changing the stored fields and add a migration from the previous
+// version to snapshotMigrations, so existing baselines remain usable.
+const snapshotVersion = 1
+
+// snapshotMigrations holds the migrations from each version to the next one,
+// indexed by the version migrated from.
+var snapshotMigrations = []func(s *snapshot){
+	// Snapshots written before versioning was added have the same layout
+	// as version 1.
+	0: func(s *snapshot) {},
+}
+
+// snapshotClient is a client as stored in a snapshot.
+type snapshotClient struct {
+	IP      string `json:"ip"`
+	Feature string `json:"feature"`
+	Release string `json:"release"`
+}
+
+func newSnapshot(clients []*Client) *snapshot {
+	s := &snapshot{Version: snapshotVersion}
+	for _, c := range clients {
+		s.Clients = append(s.Clients, snapshotClient{
+			IP:      c.IP(),
+			Feature: c.Features.String(),
+			Release: string(c.Release),
+		})
+	}
+	sort.Slice(s.Clients, func(i, j int) bool {
+		return s.Clients[i].IP < s.Clients[j].IP
+	})
+	return s
+}
+
+// readSnapshot reads the named snapshot, migrating it to the current version.
+// If the file does not exist an empty snapshot is returned.
+func readSnapshot(name string) (*snapshot, error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return &snapshot{Version: snapshotVersion}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &snapshot{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Version < 0 || s.Version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, the newest supported version is %d", s.Version, snapshotVersion)
+	}
+	for s.Version < snapshotVersion {
+		snapshotMigrations[s.Version](s)
+		s.Version++
+	}
+	return s, nil
+}
+
+func (s *snapshot) write(name string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// equal reports whether both snapshots contain the same clients.
+func (s *snapshot) equal(o *snapshot) bool {
+	if len(s.Clients) != len(o.Clients) {
+		return false
+	}
+	for i := range s.Clients {
+		if s.Clients[i] != o.Clients[i] {
+			return false
+		}
+	}
+	return true
+}
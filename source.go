@@ -0,0 +1,289 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sessionSource retrieves the raw sessions of the monitor on a host. The
+// source is selected using -source, depending on how the cluster is deployed.
+type sessionSource interface {
+	sessions(host string) ([]byte, error)
+}
+
+// Session sources as selected by -source.
+const (
+	sourceSSH     = "ssh"
+	sourceLocal   = "local"
+	sourceCephadm = "cephadm"
+	sourceRook    = "rook"
+)
+
+// daemonSource runs 'ceph daemon <mon> sessions' on each monitor host, using
+// r to reach it. It is used for the ssh and local sources.
+type daemonSource struct {
+	r       runner
+	wrapper string
+	// detectSocket enables looking up the monitor admin socket on the host
+	// instead of assuming the monitor is named mon.<host>.
+	detectSocket bool
+	// asokGlob, if set, is the pattern of the admin sockets looked up
+	// instead of the sockets in socketDir.
+	asokGlob string
+}
+
+func (src *daemonSource) sessions(host string) ([]byte, error) {
+	target, err := src.monTarget(host)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := src.sessionsCommand(host, target, true)
+	out, err := src.r.Run(host, cmd)
+	if isExitError(err) && src.wrapper == "" {
+		// Older ceph versions may not support --format for daemon
+		// commands, retry using the default format. Other errors, e.g.
+		// an unreachable host, would only fail again.
+		log.Printf("unable to execute '%s' on %s, retrying without --format: %v\n", cmd, host, err)
+		cmd = src.sessionsCommand(host, target, false)
+		out, err = src.r.Run(host, cmd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute '%s': %v", cmd, err)
+	}
+	return out, nil
+}
+
+// socketDir is the directory holding the Ceph admin sockets.
+const socketDir = "/var/run/ceph"
+
+// monTarget returns the admin socket of the monitor running on host as
+// passed to 'ceph daemon'. If no socket can be found, mon.<host> is returned,
+// or mon.<short host name> with a wrapper, which is passed the monitor id.
+// An error is only returned if the host could not be reached.
+func (src *daemonSource) monTarget(host string) (string, error) {
+	target := "mon." + host
+	if src.wrapper != "" {
+		target = "mon." + shortHost(host)
+	}
+	if !src.detectSocket {
+		return target, nil
+	}
+
+	// The socket directory is usually only accessible to the ceph user.
+	cmd, dir := "sudo ls "+socketDir, socketDir+"/"
+	if src.asokGlob != "" {
+		// ls prints the matching paths.
+		cmd, dir = "sudo "+globCommand(src.asokGlob), ""
+	}
+	out, err := src.r.Run(host, cmd)
+	if err != nil && !isExitError(err) {
+		return "", fmt.Errorf("unable to execute '%s': %v", cmd, err)
+	}
+	if err != nil {
+		log.Printf("unable to list admin sockets on %s, using %s: %v\n", host, target, err)
+		return target, nil
+	}
+
+	// Sockets are named <cluster>-mon.<id>.asok, prefer the one whose id
+	// matches the host name.
+	var sockets []string
+	for _, name := range strings.Fields(string(out)) {
+		base := path.Base(name)
+		if strings.Contains(base, "-mon.") && strings.HasSuffix(base, ".asok") {
+			sockets = append(sockets, dir+name)
+		}
+	}
+	if len(sockets) == 0 {
+		return target, nil
+	}
+	short := shortHost(host)
+	for _, s := range sockets {
+		if strings.HasSuffix(s, "-mon."+short+".asok") {
+			return s, nil
+		}
+	}
+	return sockets[0], nil
+}
+
+// globCommand returns the command listing the admin sockets matching glob.
+// The glob is expanded by a shell run with sudo, as the directories of
+// cephadm deployments are not accessible to the ssh user. The glob must be
+// accepted by validGlob.
+func globCommand(glob string) string {
+	return "sh -c 'ls -d " + glob + "'"
+}
+
+// validGlob reports whether glob only consists of characters of path names
+// and the wildcards *, ? and [...], none of which the shell running
+// globCommand interprets otherwise.
+func validGlob(glob string) bool {
+	if glob == "" {
+		return false
+	}
+	for _, r := range glob {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("/._-+:@,*?[]!", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isExitError reports whether err is the non-zero exit status of a command
+// which did run, as opposed to e.g. a failure to connect to the host.
+func isExitError(err error) bool {
+	var sshErr *ssh.ExitError
+	var execErr *exec.ExitError
+	return errors.As(err, &sshErr) || errors.As(err, &execErr)
+}
+
+// sessionsCommand returns the command retrieving the sessions of the monitor
+// running on host, explicitly requesting JSON output if formatJSON is set.
+// Target is either the monitor name or its admin socket.
+func (src *daemonSource) sessionsCommand(host, target string, formatJSON bool) string {
+	if src.wrapper != "" {
+		return fmt.Sprintf("sudo %s %s", src.wrapper, monID(target))
+	}
+	if formatJSON {
+		return fmt.Sprintf("sudo ceph --format json daemon %s sessions", target)
+	}
+	return fmt.Sprintf("sudo ceph daemon %s sessions", target)
+}
+
+// monID returns the monitor id of target, which is either mon.<id> or the
+// admin socket <cluster>-mon.<id>.asok.
+func monID(target string) string {
+	base := path.Base(target)
+	if i := strings.Index(base, "-mon."); i >= 0 && strings.HasSuffix(base, ".asok") {
+		return strings.TrimSuffix(base[i+len("-mon."):], ".asok")
+	}
+	return strings.TrimPrefix(target, "mon.")
+}
+
+// shortHost returns the host name up to the first dot, or host itself if it
+// is an IP address.
+func shortHost(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	return strings.SplitN(host, ".", 2)[0]
+}
+
+// cephadmSource retrieves the sessions of monitors deployed by cephadm, which
+// run in containers, by entering the container of the monitor on each host.
+// The monitor id is assumed to be the short host name.
+type cephadmSource struct {
+	r runner
+}
+
+func (src *cephadmSource) sessions(host string) ([]byte, error) {
+	id := shortHost(host)
+	cmd := cephadmCommand(id, fmt.Sprintf("ceph --format json daemon mon.%s sessions", id))
+	out, err := src.r.Run(host, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute '%s': %v", cmd, err)
+	}
+	return out, nil
+}
+
+// cephadmCommand returns cmd run in the container of the monitor id.
+func cephadmCommand(id, cmd string) string {
+	return fmt.Sprintf("sudo cephadm shell --name mon.%s -- %s", id, cmd)
+}
+
+// cephadmRunner runs the ceph and rbd commands in the container of the
+// monitor on the host, since cephadm does not install them on the hosts.
+// Other commands are passed on unchanged.
+type cephadmRunner struct {
+	r runner
+}
+
+func (r *cephadmRunner) Run(host, cmd string) ([]byte, error) {
+	c := strings.TrimPrefix(cmd, "sudo ")
+	if strings.HasPrefix(c, "ceph ") || strings.HasPrefix(c, "rbd ") {
+		cmd = cephadmCommand(shortHost(host), c)
+	}
+	return r.r.Run(host, cmd)
+}
+
+func (r *cephadmRunner) connectTime(host string) time.Duration {
+	if ct, ok := r.r.(connectTimer); ok {
+		return ct.connectTime(host)
+	}
+	return 0
+}
+
+// tellSource retrieves the sessions using 'ceph tell mon.<id> sessions', which
+// only needs access to the cluster and not to the monitor hosts. Hosts are
+// monitor ids. It is used by the rook source, running the commands in the
+// rook toolbox.
+type tellSource struct {
+	r runner
+}
+
+func (src *tellSource) sessions(id string) ([]byte, error) {
+	cmd := fmt.Sprintf("ceph tell mon.%s sessions --format json", id)
+	out, err := src.r.Run(id, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute '%s': %v", cmd, err)
+	}
+	return out, nil
+}
+
+// localRunner executes commands on the local host, e.g. when running on a
+// monitor. The host is ignored.
+type localRunner struct {
+	// maxOutput limits the output of a command in bytes, see limitedBuffer.
+	maxOutput int64
+}
+
+func (r localRunner) Run(host, cmd string) ([]byte, error) {
+	return runLocal(exec.Command("sh", "-c", cmd), r.maxOutput)
+}
+
+// rookRunner executes commands in the rook toolbox using kubectl. A leading
+// sudo is dropped, the host is ignored.
+type rookRunner struct {
+	namespace string
+	toolbox   string
+	// maxOutput limits the output of a command in bytes, see limitedBuffer.
+	maxOutput int64
+}
+
+func (r *rookRunner) Run(host, cmd string) ([]byte, error) {
+	cmd = strings.TrimPrefix(cmd, "sudo ")
+	return runLocal(exec.Command("kubectl", "-n", r.namespace, "exec", r.toolbox, "--", "sh", "-c", cmd), r.maxOutput)
+}
+
+// runLocal runs c and returns its output of at most max bytes, including its
+// standard error in errors.
+func runLocal(c *exec.Cmd, max int64) ([]byte, error) {
+	out := &limitedBuffer{max: max}
+	var stderr bytes.Buffer
+	c.Stdout = out
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil || out.exceeded {
+		if out.exceeded {
+			return nil, out.err()
+		}
+		if _, ok := err.(*exec.ExitError); ok && stderr.Len() > 0 {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	return out.buf.Bytes(), nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestMonTargetGlob(t *testing.T) {
+	// On cephadm hosts /var/run/ceph/<fsid> is only accessible to root, so
+	// the glob only matches if expanded by the shell run with sudo.
+	const glob = "/var/run/ceph/*/ceph-mon.*.asok"
+	r := funcRunner(func(host, cmd string) ([]byte, error) {
+		if cmd == "sudo sh -c 'ls -d "+glob+"'" {
+			return []byte("/var/run/ceph/f00d/ceph-mon.mon1.asok\n/var/run/ceph/f00d/ceph-mon.mon2.asok\n"), nil
+		}
+		// The glob expanded by the unprivileged shell is passed on
+		// unchanged.
+		return []byte("ls: cannot access '" + glob + "': No such file or directory\n"), &exec.ExitError{}
+	})
+
+	src := &daemonSource{r: r, detectSocket: true, asokGlob: glob}
+	target, err := src.monTarget("mon2.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/var/run/ceph/f00d/ceph-mon.mon2.asok"; target != want {
+		t.Errorf("monTarget = %q, want %q", target, want)
+	}
+
+	unreachable := funcRunner(func(host, cmd string) ([]byte, error) {
+		return nil, errors.New("connection refused")
+	})
+	src = &daemonSource{r: unreachable, detectSocket: true, asokGlob: glob}
+	if target, err := src.monTarget("mon2.example.org"); err == nil {
+		t.Errorf("monTarget of unreachable host = %q, want error", target)
+	}
+}
+
+func TestValidGlob(t *testing.T) {
+	for glob, want := range map[string]bool{
+		"/var/run/ceph/*/ceph-mon.*.asok":         true,
+		"/var/run/ceph/[0-9a-f]*/ceph-mon.?.asok": true,
+		"":                                 false,
+		"/var/run/ceph/*.asok /etc/shadow": false,
+		"/var/run/ceph/*'; reboot; '":      false,
+		"/var/run/ceph/$(reboot)":          false,
+		"/var/run/ceph/`reboot`":           false,
+		"/var/run/ceph/*|reboot":           false,
+		"/var/run/ceph/*\\":                false,
+	} {
+		if got := validGlob(glob); got != want {
+			t.Errorf("validGlob(%q) = %v, want %v", glob, got, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// runner executes a command on a remote host and returns its standard output.
+type runner interface {
+	Run(host, cmd string) ([]byte, error)
+}
+
+// connectTimer is implemented by runners which can report how long
+// establishing the last connection to a host took.
+type connectTimer interface {
+	connectTime(host string) time.Duration
+}
+
+// limitedBuffer collects the output of a command up to max bytes, so that a
+// misbehaving host returning enormous output cannot exhaust the memory. Once
+// exceeded, writes fail and abort is called if set. Zero max means no limit.
+type limitedBuffer struct {
+	// buf is not embedded, its ReadFrom would bypass Write in io.Copy.
+	buf      bytes.Buffer
+	max      int64
+	abort    func()
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && int64(b.buf.Len()+len(p)) > b.max {
+		if !b.exceeded && b.abort != nil {
+			b.abort()
+		}
+		b.exceeded = true
+		return 0, b.err()
+	}
+	return b.buf.Write(p)
+}
+
+// err returns the error reported if the output exceeded the limit.
+func (b *limitedBuffer) err() error {
+	return errOutputTooLarge(b.max)
+}
+
+// errOutputTooLarge is returned by runners if the output of a command exceeds
+// the limit, given in bytes.
+type errOutputTooLarge int64
+
+func (e errOutputTooLarge) Error() string {
+	return fmt.Sprintf("output exceeds %d bytes (see -max-output)", int64(e))
+}
+
+// keepaliveCountMax is the number of keepalive intervals a connection may
+// stay unresponsive before it is closed, like ServerAliveCountMax of OpenSSH.
+const keepaliveCountMax = 3
+
+// sshRunner executes commands using the embedded Go SSH implementation.
+// Sessions never request a PTY, X11 or agent forwarding and only the
+// configured public key methods are used, so authentication never prompts.
+type sshRunner struct {
+	config *ssh.ClientConfig
+	port   int
+	// timeout limits establishing the connection including the SSH
+	// handshake. Zero means no timeout.
+	timeout time.Duration
+	// keepalive is the interval keepalive requests are sent in. Zero
+	// disables keepalives.
+	keepalive time.Duration
+	// gateway, if set, is the only host connected to directly, all
+	// other hosts are reached by forwarding their SSH port through it.
+	gateway string
+	// maxOutput limits the output of a command in bytes, see limitedBuffer.
+	maxOutput int64
+
+	mu      sync.Mutex
+	connect map[string]time.Duration
+}
+
+func (r *sshRunner) Run(host, cmd string) ([]byte, error) {
+	start := time.Now()
+	client, err := r.dial(host)
+	r.mu.Lock()
+	if r.connect == nil {
+		r.connect = make(map[string]time.Duration)
+	}
+	r.connect[host] = time.Since(start)
+	r.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect: %v", err)
+	}
+	defer client.Close()
+
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create session: %v", err)
+	}
+	defer sess.Close()
+
+	// The remote command keeps writing once the limit is exceeded, close
+	// the connection to abort it.
+	out := &limitedBuffer{max: r.maxOutput, abort: func() { client.Close() }}
+	sess.Stdout = out
+
+	if r.keepalive <= 0 {
+		return output(sess.Run(cmd), out)
+	}
+
+	done := make(chan struct{})
+	dead := watchConn(client, r.keepalive, done)
+	err = sess.Run(cmd)
+	close(done)
+	select {
+	case <-dead:
+		return nil, fmt.Errorf("connection unresponsive for %v", keepaliveCountMax*r.keepalive)
+	default:
+	}
+	return output(err, out)
+}
+
+// output returns the collected output of a command which ended with err.
+func output(err error, out *limitedBuffer) ([]byte, error) {
+	if out.exceeded {
+		return nil, out.err()
+	}
+	return out.buf.Bytes(), err
+}
+
+// dial connects to host, directly or through the gateway.
+func (r *sshRunner) dial(host string) (*ssh.Client, error) {
+	if r.gateway == "" || host == r.gateway {
+		return r.dialDirect(host)
+	}
+
+	gw, err := r.dialDirect(r.gateway)
+	if err != nil {
+		return nil, fmt.Errorf("gateway %s: %v", r.gateway, err)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(r.port))
+	conn, err := gw.Dial("tcp", addr)
+	if err != nil {
+		gw.Close()
+		return nil, fmt.Errorf("forwarding through gateway %s: %v", r.gateway, err)
+	}
+
+	// Forwarded connections do not support deadlines, abort a hanging
+	// handshake by closing the gateway connection instead.
+	if r.timeout > 0 {
+		t := time.AfterFunc(r.timeout, func() { gw.Close() })
+		defer t.Stop()
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, r.config)
+	if err != nil {
+		conn.Close()
+		gw.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	go func() {
+		client.Wait()
+		gw.Close()
+	}()
+	return client, nil
+}
+
+// dialDirect connects to host, bounding the TCP connect and the SSH handshake
+// by the timeout of r, so that unreachable hosts do not block until the
+// kernel gives up.
+func (r *sshRunner) dialDirect(host string) (*ssh.Client, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(r.port))
+	conn, err := net.DialTimeout("tcp", addr, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if r.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(r.timeout))
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, r.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// watchConn sends keepalive requests over client every interval until done is
+// closed. If no reply arrives for keepaliveCountMax intervals the client is
+// closed, which aborts running sessions, and the returned channel is closed.
+func watchConn(client *ssh.Client, interval time.Duration, done <-chan struct{}) <-chan struct{} {
+	dead := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		reply := make(chan error, 1)
+		pending, missed := false, 0
+		for {
+			select {
+			case <-done:
+				return
+			case err := <-reply:
+				pending = false
+				if err == nil {
+					missed = 0
+				}
+			case <-t.C:
+				if pending {
+					missed++
+					if missed >= keepaliveCountMax {
+						close(dead)
+						client.Close()
+						return
+					}
+					continue
+				}
+				pending = true
+				go func() {
+					_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+					reply <- err
+				}()
+			}
+		}
+	}()
+	return dead
+}
+
+func (r *sshRunner) connectTime(host string) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connect[host]
+}
+
+// systemSSHRunner executes commands by shelling out to the local OpenSSH
+// client, inheriting its configuration and authentication methods.
+type systemSSHRunner struct {
+	user string
+	// port is only passed to ssh if non zero, so that a Port option from
+	// ssh_config is respected.
+	port int
+	// timeout and keepalive are passed as ConnectTimeout and
+	// ServerAliveInterval if non zero.
+	timeout   time.Duration
+	keepalive time.Duration
+	// gateway is passed as ProxyJump host if set.
+	gateway string
+	// maxOutput limits the output of a command in bytes, see limitedBuffer.
+	maxOutput int64
+}
+
+func (r *systemSSHRunner) Run(host, cmd string) ([]byte, error) {
+	// Never allocate a PTY, forward X11, the agent or ports and never
+	// prompt, regardless of the ssh configuration, so that the output is
+	// the command's only and an unattended run cannot hang on a prompt.
+	args := []string{"-T", "-x", "-a",
+		"-o", "BatchMode=yes",
+		"-o", "ClearAllForwardings=yes",
+	}
+	if r.user != "" {
+		args = append(args, "-l", r.user)
+	}
+	if r.port != 0 {
+		args = append(args, "-p", strconv.Itoa(r.port))
+	}
+	if r.timeout > 0 {
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", seconds(r.timeout)))
+	}
+	if r.keepalive > 0 {
+		args = append(args,
+			"-o", fmt.Sprintf("ServerAliveInterval=%d", seconds(r.keepalive)),
+			"-o", fmt.Sprintf("ServerAliveCountMax=%d", keepaliveCountMax),
+		)
+	}
+	if r.gateway != "" && host != r.gateway {
+		jump := r.gateway
+		if r.port != 0 {
+			jump = net.JoinHostPort(jump, strconv.Itoa(r.port))
+		}
+		if r.user != "" {
+			jump = r.user + "@" + jump
+		}
+		args = append(args, "-J", jump)
+	}
+	// Stop option parsing, so that a host can never be taken as option.
+	args = append(args, "--", host, cmd)
+
+	out, err := runLocal(exec.Command("ssh", args...), r.maxOutput)
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 255 {
+		// ssh exits with 255 if it fails to connect, which is not an
+		// exit status of the remote command.
+		return nil, fmt.Errorf("ssh: %v", err)
+	}
+	return out, err
+}
+
+// checkHost returns an error if host is empty or starts with "-", e.g. in a
+// file or monmap, and could be taken as option by a command.
+func checkHost(host string) error {
+	if host == "" || strings.HasPrefix(host, "-") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	return nil
+}
+
+// seconds returns d in whole seconds, rounded up, as expected by ssh options.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
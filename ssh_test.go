@@ -0,0 +1,159 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer serves SSH on a random local port without authentication,
+// answering exec requests using reply, and returns the port.
+func startSSHServer(t *testing.T, reply func(cmd string) (string, int)) int {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config, reply)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, reply func(cmd string) (string, int)) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				if req.Type != "exec" || len(req.Payload) < 4 {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				out, status := reply(string(req.Payload[4:]))
+				fmt.Fprint(ch, out)
+				b := make([]byte, 4)
+				binary.BigEndian.PutUint32(b, uint32(status))
+				ch.SendRequest("exit-status", false, b)
+				return
+			}
+		}()
+	}
+}
+
+func TestSSHRunnerExitError(t *testing.T) {
+	port := startSSHServer(t, func(cmd string) (string, int) {
+		if cmd == "true" {
+			return "ok\n", 0
+		}
+		return "", 1
+	})
+	r := &sshRunner{config: &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}, port: port}
+
+	if out, err := r.Run("127.0.0.1", "true"); err != nil || string(out) != "ok\n" {
+		t.Errorf("Run(true) = %q, %v", out, err)
+	}
+	if _, err := r.Run("127.0.0.1", "false"); !isExitError(err) {
+		t.Errorf("Run(false) error %v, want an exit error", err)
+	}
+
+	// A host which can not be reached must not be mistaken for a failed
+	// command, which would be retried.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	r.port = closed
+	if _, err := r.Run("127.0.0.1", "true"); err == nil || isExitError(err) {
+		t.Errorf("Run on a closed port error %v, want a connection error", err)
+	}
+}
+
+// TestConcurrentCollect collects from the same runners concurrently, as
+// -clusters with -parallel does, to be run with -race.
+func TestConcurrentCollect(t *testing.T) {
+	sessions := string(fakeSessions(50))
+	port := startSSHServer(t, func(cmd string) (string, int) {
+		switch {
+		case strings.HasSuffix(cmd, " sessions"):
+			return sessions, 0
+		case cmd == "sudo ceph fsid":
+			return "7b1c3b5e-0e4b-4a8e-9d3c-2f6a5d1e0c42\n", 0
+		}
+		return "", 1
+	})
+	sr := &sshRunner{config: &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}, port: port}
+	var r runner = &dumpRunner{r: sr, dir: t.TempDir()}
+	r = &readOnlyRunner{r: r}
+
+	hosts := []string{"127.0.0.1", "localhost"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			col := &collector{r: r, src: &daemonSource{r: r}, fsid: true, state: "open", dedup: dedupIP}
+			res := col.collect(hosts)
+			if len(res.clients) != 50 {
+				t.Errorf("cluster %d: %d clients, want 50", i, len(res.clients))
+			}
+			if res.fsid == "" {
+				t.Errorf("cluster %d: no fsid", i)
+			}
+			for _, h := range hosts {
+				if r.(connectTimer).connectTime(h) <= 0 {
+					t.Errorf("cluster %d: no connect time for %s", i, h)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(sr.connect); n != len(hosts) {
+		t.Errorf("connect times of %d hosts, want %d: %v", n, len(hosts), sr.connect)
+	}
+}
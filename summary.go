@@ -0,0 +1,309 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// summaryOptions configures the optional sections of the summary.
+type summaryOptions struct {
+	// feature is the feature mask to count supporting clients for.
+	feature string
+	// matchAll requires all bits of the feature mask, see checkForFeatures.
+	matchAll bool
+	// baseline is the snapshot of an earlier run to report progress against.
+	baseline *snapshot
+	// target is the release clients should be upgraded to. If empty, the
+	// newest release of all clients is used.
+	target Release
+	// roles, if set, adds the number of clients per release for each role.
+	roles *roles
+	// sessions, if set, is the number of sessions per entity type as seen
+	// by the monitors, including those not part of the clients.
+	sessions map[string]int
+	// secureMode adds an estimate of the clients affected by restricting
+	// ms_client_mode to secure.
+	secureMode bool
+	// msg translates the texts, see messages.
+	msg messages
+	// labels (tags and fsid) are written as header.
+	labels [][2]string
+}
+
+// writeSummary writes a human readable summary of the clients to w: the number
+// of clients per release and, if a feature is given, how many of them support
+// it together with an explanation of known features. The sessions per entity
+// type and, with a baseline, the upgrade progress since the baseline are
+// added if given.
+func writeSummary(w io.Writer, clients []*Client, opts summaryOptions) error {
+	bw := bufio.NewWriter(w)
+	feature := opts.feature
+	msg := opts.msg
+
+	writeLabels(bw, opts.labels)
+	fmt.Fprintf(bw, msg.text("clients: %d\n"), len(clients))
+	writeReleases(bw, clients, "", msg)
+
+	if opts.roles != nil {
+		byRole := make(map[string][]*Client)
+		for _, c := range clients {
+			r := opts.roles.role(c)
+			byRole[r] = append(byRole[r], c)
+		}
+		for _, r := range []string{roleInfrastructure, roleTenant} {
+			fmt.Fprintf(bw, msg.text("role %s: %d clients\n"), r, len(byRole[r]))
+			writeReleases(bw, byRole[r], "  ", msg)
+		}
+	}
+
+	if opts.sessions != nil {
+		writeEntityTypes(bw, opts.sessions, msg)
+	}
+
+	if feature != "" {
+		n := featureSupport(clients, feature, opts.matchAll)
+
+		name := feature
+		info, known := lookupFeature(feature)
+		if known {
+			name = fmt.Sprintf("%s (%s)", feature, info.Name)
+		}
+		fmt.Fprintf(bw, msg.text("feature %s: %d of %d clients (%.1f%%)\n"), name, n, len(clients), percent(n, len(clients)))
+		if known {
+			fmt.Fprintf(bw, "  %s\n", info.Description)
+		}
+	}
+
+	if opts.secureMode {
+		writeSecureMode(bw, clients, msg)
+	}
+
+	if opts.baseline != nil {
+		writeProgress(bw, clients, opts.baseline, opts.target, msg)
+	}
+
+	return bw.Flush()
+}
+
+// writeLabels writes the labels (tags and fsid) as "key: value" lines.
+func writeLabels(w io.Writer, labels [][2]string) {
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s: %s\n", l[0], l[1])
+	}
+}
+
+// writeReleases writes the number of clients per release, ordered by release
+// history, prefixing each line by indent.
+func writeReleases(w io.Writer, clients []*Client, indent string, msg messages) {
+	counts := make(map[Release]int)
+	for _, c := range clients {
+		counts[c.Release]++
+	}
+	var rels []Release
+	for r := range counts {
+		rels = append(rels, r)
+	}
+	sortReleases(rels)
+	for _, r := range rels {
+		fmt.Fprintf(w, msg.text("%srelease %s: %d\n"), indent, r, counts[r])
+	}
+}
+
+// writeEntityTypes writes the number of sessions per entity type, the common
+// types first and always, so a missing type stands out.
+func writeEntityTypes(w io.Writer, counts map[string]int, msg messages) {
+	types := []string{"client", "mds", "mgr", "mon", "osd"}
+	var other []string
+	total := 0
+	for t, n := range counts {
+		total += n
+		if !contains(types, t) {
+			other = append(other, t)
+		}
+	}
+	sort.Strings(other)
+
+	fmt.Fprintf(w, msg.text("sessions: %d\n"), total)
+	for _, t := range append(types, other...) {
+		fmt.Fprintf(w, msg.text("  entity %s: %d\n"), t, counts[t])
+	}
+}
+
+// msgr2Release is the first release supporting messenger v2. Feature bit 59
+// (MSG_ADDR2) can not be used to detect it, as it is shared with FS_BTIME,
+// which jewel clients set already.
+const msgr2Release Release = "nautilus"
+
+// writeSecureMode writes an estimate of how many clients could not connect if
+// ms_client_mode was restricted to secure, which requires messenger v2,
+// supported from nautilus on. Clients supporting v2 but connected using v1 may
+// only need v2 monitor addresses in their configuration, or ms_mode=secure
+// for kernel clients. Kernel clients with msgr2 support (Linux 5.11) report
+// luminous features and are counted as without support.
+func writeSecureMode(w io.Writer, clients []*Client, msg messages) {
+	var noV2, v1, unknownRelease int
+	for _, c := range clients {
+		switch {
+		case !c.Release.Known():
+			unknownRelease++
+		case c.Release.Rank() < msgr2Release.Rank():
+			noV2++
+		case c.Msgr == "v1":
+			v1++
+		}
+	}
+	fmt.Fprintf(w, msg.text("msgr2 secure mode: %d of %d clients (%.1f%%) without msgr2 support could not connect\n"), noV2, len(clients), percent(noV2, len(clients)))
+	fmt.Fprintf(w, msg.text("  connected using v1 despite msgr2 support: %d\n"), v1)
+	if unknownRelease > 0 {
+		fmt.Fprintf(w, msg.text("  unknown release: %d\n"), unknownRelease)
+	}
+}
+
+// writeProgress writes how many clients were upgraded since the baseline and
+// how many remain below the target release.
+func writeProgress(w io.Writer, clients []*Client, base *snapshot, target Release, msg messages) {
+	if target == "" {
+		for _, c := range clients {
+			if c.Release.Rank() > target.Rank() {
+				target = c.Release
+			}
+		}
+	}
+
+	before := make(map[string]snapshotClient)
+	for _, c := range base.Clients {
+		before[c.IP] = c
+	}
+
+	var upgraded, remaining, added int
+	seen := make(map[string]bool)
+	for _, c := range clients {
+		seen[c.IP()] = true
+		if c.Release.Rank() < target.Rank() {
+			remaining++
+		}
+
+		b, ok := before[c.IP()]
+		if !ok {
+			added++
+			continue
+		}
+		if c.Release.Rank() > parseRelease(b.Release).Rank() {
+			upgraded++
+		}
+	}
+	gone := 0
+	for ip := range before {
+		if !seen[ip] {
+			gone++
+		}
+	}
+
+	fmt.Fprintf(w, msg.text("baseline: %d clients\n"), len(base.Clients))
+	fmt.Fprintf(w, msg.text("upgraded since baseline: %d\n"), upgraded)
+	fmt.Fprintf(w, msg.text("remaining below %s: %d\n"), target, remaining)
+	fmt.Fprintf(w, msg.text("new since baseline: %d\n"), added)
+	fmt.Fprintf(w, msg.text("gone since baseline: %d\n"), gone)
+}
+
+// writeReport writes a short narrative summary of the run to w, e.g. for a
+// shift handover note:
+//
+//	124 clients, 3 jewel (listed below), 12 without PTR records, 2 of 3 monitors unreachable.
+//
+// The labels (tags and fsid) are written first. Clients below target are
+// listed, or of the oldest known release if target is empty and the clients
+// run more than one release, using the values of the IP, fqdn, release and
+// entity columns, so redaction applies. Clients without PTR records are only
+// counted if dns is set, including those named overBudget as their lookup was
+// skipped.
+func writeReport(w io.Writer, cols []column, clients []*Client, monitors []monitorResult, labels [][2]string, target Release, dns bool, overBudget string, msg messages) error {
+	bw := bufio.NewWriter(w)
+
+	if target == "" {
+		var oldest, newest Release
+		for _, c := range clients {
+			if !c.Release.Known() {
+				continue
+			}
+			if oldest == "" || c.Release.Less(oldest) {
+				oldest = c.Release
+			}
+			if newest == "" || newest.Less(c.Release) {
+				newest = c.Release
+			}
+		}
+		if oldest != newest {
+			// Clients ranked below the release after the oldest one.
+			target = Release(releases[oldest.Rank()])
+		}
+	}
+
+	var (
+		outdated []*Client
+		noPTR    int
+	)
+	counts := make(map[Release]int)
+	for _, c := range clients {
+		if target != "" && c.Release.Known() && c.Release.Rank() < target.Rank() {
+			outdated = append(outdated, c)
+			counts[c.Release]++
+		}
+		if c.FQDN == "" || overBudget != "" && c.FQDN == overBudget {
+			noPTR++
+		}
+	}
+
+	writeLabels(bw, labels)
+	parts := []string{fmt.Sprintf(msg.text("%d clients"), len(clients))}
+	if len(outdated) > 0 {
+		var rels []Release
+		for r := range counts {
+			rels = append(rels, r)
+		}
+		sortReleases(rels)
+		var old []string
+		for _, r := range rels {
+			old = append(old, fmt.Sprintf("%d %s", counts[r], r))
+		}
+		parts = append(parts, strings.Join(old, ", ")+msg.text(" (listed below)"))
+	}
+	if dns {
+		parts = append(parts, fmt.Sprintf(msg.text("%d without PTR records"), noPTR))
+	}
+	unreachable := 0
+	for _, m := range monitors {
+		if m.Error != "" {
+			unreachable++
+		}
+	}
+	if unreachable > 0 {
+		parts = append(parts, fmt.Sprintf(msg.text("%d of %d monitors unreachable"), unreachable, len(monitors)))
+	} else {
+		parts = append(parts, fmt.Sprintf(msg.text("all %d monitors reachable"), len(monitors)))
+	}
+	fmt.Fprintf(bw, "%s.\n", strings.Join(parts, ", "))
+
+	if len(outdated) > 0 {
+		sortByRelease(outdated)
+		fmt.Fprintln(bw)
+		ip, fqdn := cols[columnIndex(cols, "IP")].value, cols[columnIndex(cols, "fqdn")].value
+		release, entity := cols[columnIndex(cols, "release")].value, cols[columnIndex(cols, "entity")].value
+		for _, c := range outdated {
+			host := ip(c)
+			if n := fqdn(c); n != "" {
+				host += " " + n
+			}
+			fmt.Fprintf(bw, "- %s %s (%s)\n", host, release(c), entity(c))
+		}
+	}
+
+	return bw.Flush()
+}
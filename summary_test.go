@@ -0,0 +1,39 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+func TestWriteReportNoPTR(t *testing.T) {
+	clients := []*Client{
+		{Addr: netip.MustParseAddr("10.7.3.67"), FQDN: "gw1.example.org.", Release: "luminous"},
+		{Addr: netip.MustParseAddr("10.7.3.68"), Release: "luminous"},
+		{Addr: netip.MustParseAddr("10.7.3.69"), FQDN: "unresolved", Release: "luminous"},
+	}
+	monitors := []monitorResult{{Host: "mon1"}}
+
+	tests := []struct {
+		dns        bool
+		overBudget string
+		want       string
+	}{
+		{true, "unresolved", "3 clients, 2 without PTR records, all 1 monitors reachable.\n"},
+		{true, "", "3 clients, 1 without PTR records, all 1 monitors reachable.\n"},
+		{false, "unresolved", "3 clients, all 1 monitors reachable.\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeReport(&buf, defaultColumns(), clients, monitors, nil, "", tt.dns, tt.overBudget, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("writeReport(dns %v, overBudget %q) = %q, want %q", tt.dns, tt.overBudget, got, tt.want)
+		}
+	}
+}
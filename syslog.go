@@ -0,0 +1,117 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// syslogSDID is the structured data ID of the client records. 32473 is the
+// example enterprise number reserved for documentation (RFC 5612).
+const syslogSDID = "client@32473"
+
+// syslogPriority is facility local0, severity info.
+const syslogPriority = 16*8 + 6
+
+// syslogDialTimeout limits connecting to TCP syslog servers.
+const syslogDialTimeout = 10 * time.Second
+
+// syslogStream sends one RFC 5424 message with structured data per client to
+// a syslog server, e.g. to feed the raw records to a SIEM.
+type syslogStream struct {
+	conn     net.Conn
+	tcp      bool
+	hostname string
+}
+
+// dialSyslog connects to addr, given as host:port for UDP or
+// tcp://host:port for TCP using octet counting framing (RFC 6587).
+func dialSyslog(addr string) (*syslogStream, error) {
+	network := "udp"
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		network, addr = "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "udp://"):
+		addr = strings.TrimPrefix(addr, "udp://")
+	}
+	conn, err := net.DialTimeout(network, addr, syslogDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &syslogStream{conn: conn, tcp: network == "tcp", hostname: hostname}, nil
+}
+
+// send sends the records of all clients. The cluster name, if not empty, and
+// the labels (tags and fsid) are added as parameters.
+func (s *syslogStream) send(clients []*Client, cluster string, labels [][2]string) error {
+	for _, c := range clients {
+		params := [][2]string{
+			{"ip", c.IP()},
+			{"entity", string(c.Entity)},
+			{"kind", c.Kind()},
+			{"release", string(c.Release)},
+			{"features", c.Features.String()},
+			{"fqdn", c.FQDN},
+			{"msgr", c.Msgr},
+			{"state", string(c.State)},
+		}
+		if cluster != "" {
+			params = append(params, [2]string{"cluster", cluster})
+		}
+		for _, l := range labels {
+			params = append(params, [2]string{syslogParamName(l[0]), l[1]})
+		}
+
+		var sd strings.Builder
+		sd.WriteString("[" + syslogSDID)
+		for _, p := range params {
+			fmt.Fprintf(&sd, " %s=\"%s\"", p[0], syslogEscape(p[1]))
+		}
+		sd.WriteString("]")
+
+		msg := fmt.Sprintf("<%d>1 %s %s ceph-get-clients %d client %s client %s %s",
+			syslogPriority, time.Now().Format(time.RFC3339), s.hostname, os.Getpid(), sd.String(), c.IP(), c.Release)
+		if s.tcp {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogStream) Close() error {
+	return s.conn.Close()
+}
+
+// syslogParamName replaces the characters not allowed in structured data
+// parameter names by underscores and truncates the name to 32 characters.
+func syslogParamName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c <= ' ' || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	return string(b)
+}
+
+// syslogEscape escapes the characters not allowed in structured data
+// parameter values.
+func syslogEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rbdStatus is the output of 'rbd status --format json'.
+type rbdStatus struct {
+	Watchers []struct {
+		// Address has the format ip:port/nonce, optionally prefixed by
+		// the messenger type (v1: or v2:).
+		Address string `json:"address"`
+		Client  uint64 `json:"client"`
+	} `json:"watchers"`
+}
+
+// collectWatchers adds the watchers of the given RBD images and of all images
+// in the given pools to clients. The commands are run on the first host on
+// which they succeed. Watchers which are already known from the monitor
+// sessions only get the image added to their Watches.
+func (col *collector) collectWatchers(hosts, images, pools []string, clients []*Client) []*Client {
+	byAddr := make(map[netip.Addr]*Client)
+	for _, c := range clients {
+		for _, a := range c.addrs() {
+			if _, ok := byAddr[a]; !ok {
+				byAddr[a] = c
+			}
+		}
+	}
+
+	for _, h := range hosts {
+		all := append([]string(nil), images...)
+		var err error
+		for _, p := range pools {
+			var l []string
+			l, err = col.listImages(h, p)
+			if err != nil {
+				break
+			}
+			all = append(all, l...)
+		}
+		if err != nil {
+			log.Printf("unable to list images on %s: %v\n", h, err)
+			continue
+		}
+
+		for _, img := range all {
+			var st *rbdStatus
+			st, err = col.imageStatus(h, img)
+			if err != nil {
+				break
+			}
+			for _, w := range st.Watchers {
+				ip, err := watcherIP(w.Address)
+				if err != nil {
+					log.Printf("unable to parse watcher address %q of %s: %v\n", w.Address, img, err)
+					continue
+				}
+				clients = addWatcher(clients, byAddr, ip, w.Client, img)
+			}
+		}
+		if err != nil {
+			log.Printf("unable to get image status on %s: %v\n", h, err)
+			continue
+		}
+		return clients
+	}
+	return clients
+}
+
+func (col *collector) listImages(host, pool string) ([]string, error) {
+	out, err := col.r.Run(host, "sudo rbd ls --format json "+pool)
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	if err := json.Unmarshal(out, &images); err != nil {
+		return nil, fmt.Errorf("pool %s: %v", pool, err)
+	}
+	for i, img := range images {
+		images[i] = pool + "/" + img
+	}
+	return images, nil
+}
+
+func (col *collector) imageStatus(host, image string) (*rbdStatus, error) {
+	out, err := col.r.Run(host, "sudo rbd status --format json "+image)
+	if err != nil {
+		return nil, err
+	}
+	st := &rbdStatus{}
+	if err := json.Unmarshal(out, st); err != nil {
+		return nil, fmt.Errorf("image %s: %v", image, err)
+	}
+	return st, nil
+}
+
+// watcherIP returns the IP of a watcher address.
+func watcherIP(addr string) (netip.Addr, error) {
+	addr = strings.TrimPrefix(strings.TrimPrefix(addr, "v1:"), "v2:")
+	if i := strings.LastIndex(addr, "/"); i >= 0 {
+		addr = addr[:i]
+	}
+	return parseAddr(addr)
+}
+
+// addWatcher records that the client with the given IP, looked up in byAddr,
+// watches image. Unknown watchers are added to clients and byAddr.
+func addWatcher(clients []*Client, byAddr map[netip.Addr]*Client, ip netip.Addr, id uint64, image string) []*Client {
+	if c, ok := byAddr[ip]; ok {
+		if !contains(c.Watches, image) {
+			c.Watches = append(c.Watches, image)
+		}
+		return clients
+	}
+
+	c := &Client{
+		Addr:     ip,
+		Family:   addrFamily(ip),
+		Entity:   Entity("client." + strconv.FormatUint(id, 10)),
+		GlobalID: id,
+		State:    stateOpen,
+		Release:  unknown,
+		Msgr:     unknown,
+		Watches:  []string{image},
+		SeenAt:   time.Now(),
+	}
+	byAddr[ip] = c
+	return append(clients, c)
+}
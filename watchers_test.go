@@ -0,0 +1,53 @@
+// Copyright 2020 Eurac Research. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// funcRunner runs commands by calling the function.
+type funcRunner func(host, cmd string) ([]byte, error)
+
+func (f funcRunner) Run(host, cmd string) ([]byte, error) {
+	return f(host, cmd)
+}
+
+func TestCollectWatchers(t *testing.T) {
+	r := funcRunner(func(host, cmd string) ([]byte, error) {
+		switch {
+		case cmd == "sudo rbd ls --format json rbd":
+			return []byte(`["vm-1","vm-2"]`), nil
+		case strings.HasSuffix(cmd, " rbd/vm-1"):
+			return []byte(`{"watchers":[{"address":"v2:[fd00::1]:0/1","client":1},{"address":"10.7.3.99:0/2","client":99}]}`), nil
+		case strings.HasSuffix(cmd, " rbd/vm-2"):
+			return []byte(`{"watchers":[{"address":"10.7.3.99:0/2","client":99},{"address":"10.7.3.2:0/3","client":2}]}`), nil
+		}
+		return nil, fmt.Errorf("unexpected command %q", cmd)
+	})
+
+	dual := &Client{Addr: netip.MustParseAddr("10.7.3.1"), MergedAddrs: []netip.Addr{netip.MustParseAddr("fd00::1")}}
+	other := &Client{Addr: netip.MustParseAddr("10.7.3.2")}
+	col := &collector{r: r}
+	clients := col.collectWatchers([]string{"mon1"}, nil, []string{"rbd"}, []*Client{dual, other})
+
+	if len(clients) != 3 {
+		t.Fatalf("%d clients, want 3: %v", len(clients), clients)
+	}
+	if want := []string{"rbd/vm-1"}; !reflect.DeepEqual(dual.Watches, want) {
+		t.Errorf("dual stack client watches %v, want %v", dual.Watches, want)
+	}
+	if want := []string{"rbd/vm-2"}; !reflect.DeepEqual(other.Watches, want) {
+		t.Errorf("client watches %v, want %v", other.Watches, want)
+	}
+	added := clients[2]
+	if added.IP() != "10.7.3.99" || added.Entity != "client.99" || !reflect.DeepEqual(added.Watches, []string{"rbd/vm-1", "rbd/vm-2"}) {
+		t.Errorf("added watcher %s %s watches %v", added.IP(), added.Entity, added.Watches)
+	}
+}